@@ -0,0 +1,150 @@
+package tgx
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// BotAPI is the Telegram Bot API client used by tgx.
+//
+// The default one is TgBotAPI, calling Telegram by tgbotapi.
+// Fakes can be injected by SingleChatConf.Bot or TgWrapper.SetBotFactory(), e.g. to test without network.
+type BotAPI interface {
+	Token() string
+	Self() tgbotapi.User // The bot itself, as returned by getMe.
+
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+}
+
+// ContextBotAPI is implemented by the BotAPIs able to send requests with a context.
+// BotAPIs not implementing it ignore the context of the requests, only the retries and waits stop when it is done.
+type ContextBotAPI interface {
+	BotAPI
+	// WithContext returns the BotAPI sending its requests with ctx.
+	WithContext(ctx context.Context) BotAPI
+}
+
+// Create the BotAPI of the token.
+type BotFactory func(botToken string) (BotAPI, error)
+
+// TgBotAPI is the default BotAPI, calling Telegram by tgbotapi.
+// Use chat.Bot.(*TgBotAPI).BotAPI for the methods not wrapped by tgx.
+type TgBotAPI struct {
+	*tgbotapi.BotAPI
+}
+
+// NewBotAPI creates the default BotAPI of the token, talking to api.telegram.org. It calls getMe to check the token.
+func NewBotAPI(botToken string) (BotAPI, error) {
+	return NewBotAPIWithEndpoint(botToken, "", nil)
+}
+
+// NewBotAPIWithEndpoint creates the default BotAPI talking to the Bot API server at apiEndpoint with the client,
+// e.g. a local telegram-bot-api server. It calls getMe to check the token.
+//
+// apiEndpoint is the base URL like "http://localhost:8081", or the format used by tgbotapi like "http://localhost:8081/bot%s/%s".
+// "" for api.telegram.org. client nil for a default http.Client.
+func NewBotAPIWithEndpoint(botToken string, apiEndpoint string, client *http.Client) (BotAPI, error) {
+	if client == nil {
+		client = &http.Client{}
+	}
+	bot, err := tgbotapi.NewBotAPIWithClient(botToken, apiEndpointFormat(apiEndpoint), client)
+	if err != nil {
+		return nil, err
+	}
+	return &TgBotAPI{BotAPI: bot}, nil
+}
+
+// The format of the API endpoint used by tgbotapi, with the token and the method.
+func apiEndpointFormat(endpoint string) string {
+	if endpoint == "" {
+		return tgbotapi.APIEndpoint
+	}
+	if strings.Contains(endpoint, "%s") {
+		return endpoint
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/bot%s/%s"
+}
+
+// The format of the file endpoint, with the token and the file path.
+// Defaults to the one of the API endpoint if not set.
+func fileEndpointFormat(fileEndpoint string, apiEndpoint string) string {
+	if fileEndpoint == "" {
+		if apiEndpoint == "" || strings.Contains(apiEndpoint, "%s") {
+			return tgbotapi.FileEndpoint
+		}
+		fileEndpoint = apiEndpoint
+	}
+	if strings.Contains(fileEndpoint, "%s") {
+		return fileEndpoint
+	}
+	return strings.TrimSuffix(fileEndpoint, "/") + "/file/bot%s/%s"
+}
+
+func (bot *TgBotAPI) Token() string       { return bot.BotAPI.Token }
+func (bot *TgBotAPI) Self() tgbotapi.User { return bot.BotAPI.Self }
+
+// WithContext returns a shallow copy of the bot, sharing everything except the HTTP client.
+func (bot *TgBotAPI) WithContext(ctx context.Context) BotAPI {
+	clone := *bot.BotAPI
+	clone.Client = contextClient{ctx: ctx, client: bot.BotAPI.Client}
+	return &TgBotAPI{BotAPI: &clone}
+}
+
+type contextClient struct {
+	ctx    context.Context
+	client tgbotapi.HTTPClient
+}
+
+func (c contextClient) Do(req *http.Request) (*http.Response, error) {
+	return c.client.Do(req.WithContext(c.ctx))
+}
+
+// Set how the wrapper creates the BotAPI of a bot token when registering its first chat,
+// default NewBotAPIWithEndpoint() with the endpoint and HTTP client of the wrapper.
+// SingleChatConf.Bot takes precedence if set.
+func (tg *TgWrapper) SetBotFactory(factory BotFactory) { tg.botFactory = factory }
+
+// Set the Bot API server of the bots created by the wrapper, e.g. "http://localhost:8081" for a local telegram-bot-api server.
+// Default api.telegram.org. SingleChatConf.APIEndpoint takes precedence if set.
+func (tg *TgWrapper) SetAPIEndpoint(endpoint string) { tg.apiEndpoint = endpoint }
+
+// Set the base URL of downloading files, see Chat.GetFileURL(). Default the API endpoint.
+// SingleChatConf.FileEndpoint takes precedence if set.
+func (tg *TgWrapper) SetFileEndpoint(endpoint string) { tg.fileEndpoint = endpoint }
+
+// Set the HTTP client of the bots created by the wrapper, e.g. for proxies, timeouts and TLS.
+// SingleChatConf.HTTPClient takes precedence if set.
+func (tg *TgWrapper) SetHTTPClient(client *http.Client) { tg.httpClient = client }
+
+// The BotAPI of the conf: conf.Bot, or the one created by the bot factory.
+func (tg *TgWrapper) newBotAPI(conf SingleChatConf) (BotAPI, error) {
+	if conf.Bot != nil {
+		return conf.Bot, nil
+	}
+	if tg.botFactory != nil {
+		return tg.botFactory(conf.BotToken)
+	}
+	return NewBotAPIWithEndpoint(conf.BotToken, tg.apiEndpointOf(conf), cmp.Or(conf.HTTPClient, tg.httpClient))
+}
+
+func (tg *TgWrapper) apiEndpointOf(conf SingleChatConf) string {
+	return cmp.Or(conf.APIEndpoint, tg.apiEndpoint)
+}
+
+func (tg *TgWrapper) fileEndpointOf(conf SingleChatConf) string {
+	return fileEndpointFormat(cmp.Or(conf.FileEndpoint, tg.fileEndpoint), tg.apiEndpointOf(conf))
+}
+
+// The bot sending the HTTP requests with ctx, so they are cancelled when ctx is done.
+func (chat *Chat) botWithContext(ctx context.Context) BotAPI {
+	if bot, ok := chat.Bot.(ContextBotAPI); ok && ctx.Done() != nil {
+		return bot.WithContext(ctx)
+	}
+	return chat.Bot
+}
@@ -1,42 +1,86 @@
 package tgx
 
 import (
+	"cmp"
+	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
-	"github.com/0xVanfer/tgx/internal/tgxerrors"
 	"github.com/0xVanfer/tgx/internal/tgxutils"
+	"github.com/0xVanfer/tgx/tgxerrors"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 type Chat struct {
-	Bot *tgbotapi.BotAPI
+	Bot BotAPI
+
+	// The wrapper the chat is registered to, nil if the chat is not created by a wrapper.
+	wrapper *TgWrapper
 
 	ChatID      int64
 	ChatTopic   int
 	Identifier  string
 	Description string
 
+	// Guards ChatID, ChatTopic and Description, which may be changed by TgWrapper.ApplyConfig().
+	targetMu sync.RWMutex
+
+	// The format of the file download URL, with the token and the file path.
+	fileEndpoint string
+
+	// Guards disableWebPagePreview and the retry settings, which may be changed by TgWrapper.ApplyConfig()
+	// while requests are in flight.
+	settingsMu sync.RWMutex
+
 	// I don't like the web page preview, so I set it to true by default.
 	// If you want to enable it, use SetDisableWebPagePreview() to set it
 	disableWebPagePreview bool
 
 	// Set retry times and interval.
-	retry         int
-	retryInterval time.Duration
+	// Retryable errors wait for retryInterval, doubled after each retry up to retryMaxInterval;
+	// flood waits (429) wait for the retry_after given by Telegram, up to maxFloodWait.
+	retry            int
+	retryInterval    time.Duration
+	retryMaxInterval time.Duration
+	maxFloodWait     time.Duration
 
 	// map[identifier] = msg info || []msg info
 	managedMsgs sync.Map
 
+	// Guards commands, commandAliases, handleMsgFuncs and handleUpdateFuncs,
+	// so handlers can be registered at any time, even while monitoring.
+	handlersMu sync.RWMutex
+
 	// To handle commands.
-	// map[command string] = func(msg *tgbotapi.Message) (err error)
-	handleCommandFuncs map[string]func(msg *tgbotapi.Message) (err error)
+	// map[lower case command name] = command
+	// map[lower case alias] = lower case command name
+	commands       map[string]*Command
+	commandAliases map[string]string
 
 	// To handle normal messages.
-	// Each different logic can be registered with a different function.
-	// To manage the functions, use a map to make registered functions easy to find.
-	handleMsgFuncs map[string]func(msg *tgbotapi.Message) (err error)
+	// Each different logic can be registered with a different function, identified by funcIdentifier.
+	// Sorted by priority, so handlers run in a deterministic order.
+	handleMsgFuncs []*registeredHandler
+
+	// To handle the other update kinds, such as edited messages and channel posts.
+	// map[update kind] = handlers sorted by priority
+	handleUpdateFuncs map[UpdateKind][]*registeredHandler
+
+	handlerSeq         uint64 // Registration counter of the handlers.
+	handleConcurrently bool   // Run the handlers of an update concurrently. Use SetHandleConcurrently() to set.
+
+	// nil to use the logger of the wrapper. Use SetLogger() to set.
+	logger *slog.Logger
+
+	// nil to use the error handler of the wrapper. Use SetErrorHandler() to set.
+	errorHandler ErrorHandler
+
+	// Middlewares wrapping all handlers of the chat. Use Use() to add.
+	middlewares   []Middleware
+	middlewaresMu sync.RWMutex
 }
 
 type ChatAndTopic struct {
@@ -44,6 +88,22 @@ type ChatAndTopic struct {
 	ChatTopic int
 }
 
+// The chat ID and topic of the chat.
+// Use it instead of reading ChatID and ChatTopic if the config may be reloaded, see TgWrapper.ApplyConfig().
+func (chat *Chat) Target() ChatAndTopic {
+	chat.targetMu.RLock()
+	defer chat.targetMu.RUnlock()
+	return ChatAndTopic{ChatID: chat.ChatID, ChatTopic: chat.ChatTopic}
+}
+
+func (chat *Chat) setTarget(chatID int64, topic int, description string) {
+	chat.targetMu.Lock()
+	defer chat.targetMu.Unlock()
+	chat.ChatID = chatID
+	chat.ChatTopic = topic
+	chat.Description = description
+}
+
 // Turn the msg into a ChatAndTopic struct.
 func (chat *Chat) GetOverrideInfoFromMsg(msg *tgbotapi.Message) *ChatAndTopic {
 	if msg == nil {
@@ -66,6 +126,11 @@ func (chat *Chat) GetOverrideInfoFromMsg(msg *tgbotapi.Message) *ChatAndTopic {
 // If text is too long, it will be split into multiple messages.
 // The maximum length of a single message is 4096 characters.
 func (chat *Chat) SendTextMsg(targetChatOverride *ChatAndTopic, text string) (msgsSent []*tgbotapi.Message, err error) {
+	return chat.SendTextMsgContext(context.Background(), targetChatOverride, text)
+}
+
+// Same as SendTextMsg(), stopping the retries, rate limit waits and HTTP requests when ctx is done.
+func (chat *Chat) SendTextMsgContext(ctx context.Context, targetChatOverride *ChatAndTopic, text string) (msgsSent []*tgbotapi.Message, err error) {
 	var spiltText []string
 	for len(text) > 4096 {
 		spiltText = append(spiltText, text[:4096])
@@ -76,7 +141,7 @@ func (chat *Chat) SendTextMsg(targetChatOverride *ChatAndTopic, text string) (ms
 	}
 
 	for _, t := range spiltText {
-		msg, e := chat.sendTextMsg(targetChatOverride, t, nil)
+		msg, e := chat.sendTextMsg(ctx, targetChatOverride, t, nil)
 		if e != nil {
 			return nil, e
 		}
@@ -89,6 +154,11 @@ func (chat *Chat) SendTextMsg(targetChatOverride *ChatAndTopic, text string) (ms
 //
 // The entities should be defined in the MsgComponent struct. And total entities length must be no longer than 100.
 func (chat *Chat) SendTextMsgByComponents(targetChatOverride *ChatAndTopic, components ...[]MsgComponent) (msgsSent []*tgbotapi.Message, err error) {
+	return chat.SendTextMsgByComponentsContext(context.Background(), targetChatOverride, components...)
+}
+
+// Same as SendTextMsgByComponents(), stopping the retries, rate limit waits and HTTP requests when ctx is done.
+func (chat *Chat) SendTextMsgByComponentsContext(ctx context.Context, targetChatOverride *ChatAndTopic, components ...[]MsgComponent) (msgsSent []*tgbotapi.Message, err error) {
 	for _, component := range components {
 		text, entities := CompileMsgComponents(component...)
 		if len(text) == 0 {
@@ -101,7 +171,7 @@ func (chat *Chat) SendTextMsgByComponents(targetChatOverride *ChatAndTopic, comp
 		if len(entities) > 100 {
 			return nil, tgxerrors.ErrTooManyEntities
 		}
-		msgSent, err := chat.sendTextMsg(targetChatOverride, text, entities)
+		msgSent, err := chat.sendTextMsg(ctx, targetChatOverride, text, entities)
 		if err != nil {
 			return nil, err
 		}
@@ -115,6 +185,11 @@ func (chat *Chat) SendTextMsgByComponents(targetChatOverride *ChatAndTopic, comp
 // If sending a local file, photoPath should be the path to the file.
 // If sending a online file, photoPath should be the URL to the file.
 func (chat *Chat) SendPhoto(targetChatOverride *ChatAndTopic, photoPath string, isLocal bool) (msgSent *tgbotapi.Message, err error) {
+	return chat.SendPhotoContext(context.Background(), targetChatOverride, photoPath, isLocal)
+}
+
+// Same as SendPhoto(), stopping the retries, rate limit waits and the upload when ctx is done.
+func (chat *Chat) SendPhotoContext(ctx context.Context, targetChatOverride *ChatAndTopic, photoPath string, isLocal bool) (msgSent *tgbotapi.Message, err error) {
 	var photo tgbotapi.RequestFileData
 	if isLocal {
 		photo = tgbotapi.FilePath(photoPath)
@@ -128,60 +203,91 @@ func (chat *Chat) SendPhoto(targetChatOverride *ChatAndTopic, photoPath string,
 	if topic != 0 {
 		msg.ReplyToMessageID = topic
 	}
-	return chat.sendWithRetry(msg)
+	return chat.sendWithRetry(ctx, msg)
 }
 
-func (chat *Chat) RegisterHandleCommand(command string, handleFunc func(msg *tgbotapi.Message) (err error)) {
-	if chat.handleCommandFuncs == nil {
-		chat.handleCommandFuncs = make(map[string]func(msg *tgbotapi.Message) (err error))
-	}
-	// Map must be initialized when the chat is created.
-	chat.handleCommandFuncs[command] = func(msg *tgbotapi.Message) (err error) {
-		defer func() {
-			if r := recover(); r != nil {
-				err = fmt.Errorf("tgx: handle command [%s] panic: %v", command, r)
-			}
-		}()
-		return handleFunc(msg)
-	}
+// Register a command without declared arguments. Use ctx.Args().Raw() to read them.
+// Use RegisterCommand() to register a command with description, typed arguments, flags and aliases.
+func (chat *Chat) RegisterHandleCommand(command string, handleFunc Handler) {
+	chat.RegisterCommand(Command{
+		Name:   command,
+		Handle: handleFunc,
+	})
 }
 
+// HandleCommand runs the command of the msg, if registered and addressed to this bot.
+//
+// If the arguments are invalid, the usage is replied and the handler is not called.
 func (chat *Chat) HandleCommand(msg *tgbotapi.Message) error {
-	commandStr := msg.Command()
-	funcx, exist := chat.handleCommandFuncs[commandStr]
+	return chat.handleCommand(withDispatchState(context.Background()), msgToUpdate(msg))
+}
+
+func (chat *Chat) handleCommand(ctx context.Context, update *Update) error {
+	msg := update.Message
+	if !msg.IsCommand() || !chat.isCommandToMe(msg) {
+		return nil
+	}
+	cmd, exist := chat.findCommand(msg.Command())
 	if !exist {
 		return nil
 	}
-	return funcx(msg)
+	return chat.runWithTimeout(chat.newContext(ctx, update), chat.wrapHandler(func(ctx *Context) error {
+		args, err := cmd.parseArgs(msg.CommandArguments())
+		if err != nil {
+			return chat.replyUsage(ctx, msg, cmd, err)
+		}
+		ctx.args = args
+		return cmd.Handle(ctx)
+	}))
 }
 
-func (chat *Chat) RegisterHandleMsg(funcIdentifier string, handleFunc func(msg *tgbotapi.Message) (err error)) {
-	if chat.handleMsgFuncs == nil {
-		chat.handleMsgFuncs = make(map[string]func(msg *tgbotapi.Message) (err error))
+// Register a function to handle normal messages.
+// Handlers run in the order of priority (see WithPriority()), then the order they are registered.
+// Registering with an existing funcIdentifier replaces the handler.
+func (chat *Chat) RegisterHandleMsg(funcIdentifier string, handleFunc Handler, opts ...HandlerOption) {
+	h := &registeredHandler{
+		identifier: funcIdentifier,
+		handle: func(ctx *Context) (err error) {
+			defer chat.recoverHandler("msg", funcIdentifier, &err)
+			return handleFunc(ctx)
+		},
 	}
-	// Map must be initialized when the chat is created.
-	chat.handleMsgFuncs[funcIdentifier] = func(msg *tgbotapi.Message) (err error) {
-		defer func() {
-			if r := recover(); r != nil {
-				err = fmt.Errorf("tgx: handle msg [%s] panic: %v", funcIdentifier, r)
-			}
-		}()
-		return handleFunc(msg)
+	for _, opt := range opts {
+		opt(h)
 	}
+
+	chat.handlersMu.Lock()
+	chat.handleMsgFuncs = chat.addHandler(chat.handleMsgFuncs, h)
+	chat.handlersMu.Unlock()
+
+	chat.onHandlerRegistered()
+}
+
+// Unregister the msg handler. Nothing happens if it is not registered.
+func (chat *Chat) UnregisterHandleMsg(funcIdentifier string) {
+	chat.handlersMu.Lock()
+	defer chat.handlersMu.Unlock()
+	chat.handleMsgFuncs = removeHandler(chat.handleMsgFuncs, funcIdentifier)
 }
 
 func (chat *Chat) HandleMsg(msg *tgbotapi.Message) map[string]error {
-	errorRes := make(map[string]error)
-	for identidier, funcx := range chat.handleMsgFuncs {
-		if funcx == nil {
-			continue
-		}
-		err := funcx(msg)
-		if err != nil {
-			errorRes[identidier] = err
-		}
+	return chat.handleMsg(withDispatchState(context.Background()), msgToUpdate(msg))
+}
+
+func (chat *Chat) handleMsg(ctx context.Context, update *Update) map[string]error {
+	chat.handlersMu.RLock()
+	handlers := append([]*registeredHandler{}, chat.handleMsgFuncs...)
+	concurrently := chat.handleConcurrently
+	chat.handlersMu.RUnlock()
+
+	return chat.runHandlers(ctx, update, handlers, concurrently)
+}
+
+// Start monitoring the bot of the chat, if the wrapper is monitoring and the bot was not monitored for lack of handlers.
+func (chat *Chat) onHandlerRegistered() {
+	if chat.wrapper != nil && chat.Bot != nil {
+		chat.wrapper.startPolling(chat.Bot.Token())
 	}
-	return errorRes
 }
 
 // ========== Msg Related ==========
@@ -277,68 +383,203 @@ func (chat *Chat) GetMsgs(identifier string) ([]*ChatMsg, error) {
 }
 
 func (chat *Chat) DeleteMsg(msg *tgbotapi.Message) error {
+	return chat.DeleteMsgContext(context.Background(), msg)
+}
+
+// Same as DeleteMsg(), stopping the retries and HTTP requests when ctx is done.
+func (chat *Chat) DeleteMsgContext(ctx context.Context, msg *tgbotapi.Message) error {
 	if msg == nil || msg.Chat == nil {
 		return tgxerrors.ErrMsgNotFound
 	}
 	deletingMsg := tgbotapi.NewDeleteMessage(msg.Chat.ID, msg.MessageID)
-	_, err := chat.sendWithRetry(deletingMsg)
-	if err != nil {
-		return err
-	}
-	return nil
+	return chat.requestWithRetry(ctx, deletingMsg)
 }
 
 // DeleteMsgs deletes the tg messages with the given identifier, and free the identidier.
 func (chat *Chat) DeleteMsgs(identifier string) error {
+	return chat.DeleteMsgsContext(context.Background(), identifier)
+}
+
+// Same as DeleteMsgs(), stopping the retries and HTTP requests when ctx is done.
+// The identifier is freed only if ctx is not done.
+func (chat *Chat) DeleteMsgsContext(ctx context.Context, identifier string) error {
 	msgs, err := chat.GetMsgs(identifier)
 	if err != nil {
 		return err
 	}
 	for _, msg := range msgs {
-		_ = msg.Delete()
+		_ = msg.DeleteContext(ctx)
+	}
+	if err = ctx.Err(); err != nil {
+		return err
 	}
 	chat.managedMsgs.Delete(identifier)
 	return nil
 }
 
+// DeleteAllMsgs deletes all managed tg messages of the chat, and free their identifiers.
+func (chat *Chat) DeleteAllMsgs() error {
+	return chat.DeleteAllMsgsContext(context.Background())
+}
+
+// Same as DeleteAllMsgs(), stopping the retries and HTTP requests when ctx is done.
+func (chat *Chat) DeleteAllMsgsContext(ctx context.Context) error {
+	var identifiers []string
+	chat.managedMsgs.Range(func(key, _ any) bool {
+		if identifier, ok := key.(string); ok {
+			identifiers = append(identifiers, identifier)
+		}
+		return true
+	})
+	for _, identifier := range identifiers {
+		err := chat.DeleteMsgsContext(ctx, identifier)
+		if err != nil && err != tgxerrors.ErrIdentifierNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
 func (chat *Chat) DeleteMsgByID(chatID int64, msgID int) error {
+	return chat.DeleteMsgByIDContext(context.Background(), chatID, msgID)
+}
+
+// Same as DeleteMsgByID(), stopping the retries and HTTP requests when ctx is done.
+func (chat *Chat) DeleteMsgByIDContext(ctx context.Context, chatID int64, msgID int) error {
 	msg := tgbotapi.NewDeleteMessage(chatID, msgID)
-	_, err := chat.sendWithRetry(msg)
+	return chat.requestWithRetry(ctx, msg)
+}
+
+// GetFileURL returns the URL to download the file, e.g. a photo or document received.
+// The URL contains the bot token, do not share it.
+func (chat *Chat) GetFileURL(fileID string) (string, error) {
+	return chat.GetFileURLContext(context.Background(), fileID)
+}
+
+// Same as GetFileURL(), stopping the retries and HTTP requests when ctx is done.
+func (chat *Chat) GetFileURLContext(ctx context.Context, fileID string) (string, error) {
+	bot := chat.botWithContext(ctx)
+	var file tgbotapi.File
+	err := chat.retryRequest(ctx, "request", []any{slog.String("request", "getFile")}, func() error {
+		if e := chat.waitRateLimit(ctx, nil); e != nil {
+			return e
+		}
+		resp, e := bot.Request(tgbotapi.FileConfig{FileID: fileID})
+		if e != nil {
+			return e
+		}
+		return json.Unmarshal(resp.Result, &file)
+	}, func() int { return 0 })
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	endpoint := cmp.Or(chat.fileEndpoint, tgbotapi.FileEndpoint)
+	return fmt.Sprintf(endpoint, chat.Bot.Token(), file.FilePath), nil
 }
 
 // ========== Setters ==========
 
-func (chat *Chat) SetRetry(retry int)                      { chat.retry = retry }
-func (chat *Chat) SetRetryInterval(interval time.Duration) { chat.retryInterval = interval }
-func (chat *Chat) SetDisableWebPagePreview(disable bool)   { chat.disableWebPagePreview = disable }
+func (chat *Chat) SetRetry(retry int) {
+	chat.settingsMu.Lock()
+	defer chat.settingsMu.Unlock()
+	chat.retry = retry
+}
+
+func (chat *Chat) SetRetryInterval(interval time.Duration) {
+	chat.settingsMu.Lock()
+	defer chat.settingsMu.Unlock()
+	chat.retryInterval = interval
+}
+
+func (chat *Chat) SetRetryMaxInterval(interval time.Duration) {
+	chat.settingsMu.Lock()
+	defer chat.settingsMu.Unlock()
+	chat.retryMaxInterval = interval
+}
+
+func (chat *Chat) SetMaxFloodWait(wait time.Duration) {
+	chat.settingsMu.Lock()
+	defer chat.settingsMu.Unlock()
+	chat.maxFloodWait = wait
+}
+
+func (chat *Chat) SetDisableWebPagePreview(disable bool) {
+	chat.settingsMu.Lock()
+	defer chat.settingsMu.Unlock()
+	chat.disableWebPagePreview = disable
+}
+
+func (chat *Chat) webPagePreviewDisabled() bool {
+	chat.settingsMu.RLock()
+	defer chat.settingsMu.RUnlock()
+	return chat.disableWebPagePreview
+}
+
+// The retry settings of the chat, without the callback.
+func (chat *Chat) retryPolicy() tgxutils.RetryPolicy {
+	chat.settingsMu.RLock()
+	defer chat.settingsMu.RUnlock()
+	return tgxutils.RetryPolicy{
+		MaxAttempts:  chat.retry,
+		Interval:     chat.retryInterval,
+		MaxInterval:  chat.retryMaxInterval,
+		MaxFloodWait: chat.maxFloodWait,
+	}
+}
 
 // ========== Internal ==========
 
-func (chat *Chat) sendWithRetry(msg tgbotapi.Chattable) (msgSent *tgbotapi.Message, err error) {
-	err = tgxutils.Retry(func() error {
-		newMsg, e := chat.Bot.Send(msg)
+func (chat *Chat) sendWithRetry(ctx context.Context, msg tgbotapi.Chattable) (msgSent *tgbotapi.Message, err error) {
+	event, attrs := requestAttrs(msg)
+	bot := chat.botWithContext(ctx)
+	err = chat.retryRequest(ctx, event, attrs, func() error {
+		if e := chat.waitRateLimit(ctx, msg); e != nil {
+			return e
+		}
+		newMsg, e := bot.Send(msg)
 		msgSent = &newMsg
 		return e
-	}, chat.retry, chat.retryInterval)
+	}, func() int { return msgSent.MessageID })
 	return
 }
 
+// Same as sendWithRetry(), for the requests not returning a message, e.g. setMyCommands.
+func (chat *Chat) requestWithRetry(ctx context.Context, c tgbotapi.Chattable) error {
+	event, attrs := requestAttrs(c)
+	bot := chat.botWithContext(ctx)
+	return chat.retryRequest(ctx, event, attrs, func() error {
+		if e := chat.waitRateLimit(ctx, c); e != nil {
+			return e
+		}
+		_, e := bot.Request(c)
+		return e
+	}, func() int { return 0 })
+}
+
+// Same as requestWithRetry(), for the methods without a tgbotapi config, e.g. setMessageReaction.
+func (chat *Chat) makeRequestWithRetry(ctx context.Context, endpoint string, params tgbotapi.Params) error {
+	bot := chat.botWithContext(ctx)
+	return chat.retryRequest(ctx, "request", []any{slog.String("request", endpoint)}, func() error {
+		if e := chat.waitRateLimit(ctx, nil); e != nil {
+			return e
+		}
+		_, e := bot.MakeRequest(endpoint, params)
+		return e
+	}, func() int { return 0 })
+}
+
 // Internal function.
 // Chat must be valid; text length must < 4096; entities length must < 100.
-func (chat *Chat) sendTextMsg(targetChatOverride *ChatAndTopic, text string, entities []tgbotapi.MessageEntity) (msgSent *tgbotapi.Message, err error) {
+func (chat *Chat) sendTextMsg(ctx context.Context, targetChatOverride *ChatAndTopic, text string, entities []tgbotapi.MessageEntity) (msgSent *tgbotapi.Message, err error) {
 	chatID, topic := chat.decideChatAndTopic(targetChatOverride)
 
 	msg := tgbotapi.NewMessage(chatID, text)
-	msg.DisableWebPagePreview = chat.disableWebPagePreview
+	msg.DisableWebPagePreview = chat.webPagePreviewDisabled()
 	if topic > 0 {
 		msg.ReplyToMessageID = topic
 	}
 	msg.Entities = entities
-	return chat.sendWithRetry(msg)
+	return chat.sendWithRetry(ctx, msg)
 }
 
 func (chat *Chat) decideChatAndTopic(targetChatOverride *ChatAndTopic) (chatID int64, topic int) {
@@ -346,8 +587,9 @@ func (chat *Chat) decideChatAndTopic(targetChatOverride *ChatAndTopic) (chatID i
 		chatID = targetChatOverride.ChatID
 		topic = max(targetChatOverride.ChatTopic, 0)
 	} else {
-		chatID = chat.ChatID
-		topic = max(chat.ChatTopic, 0)
+		target := chat.Target()
+		chatID = target.ChatID
+		topic = max(target.ChatTopic, 0)
 	}
 	return
 }
@@ -1,10 +1,10 @@
 package tgx
 
 import (
+	"context"
 	"fmt"
 
-	"github.com/0xVanfer/tgx/internal/tgxerrors"
-	"github.com/0xVanfer/tgx/internal/tgxutils"
+	"github.com/0xVanfer/tgx/tgxerrors"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
@@ -18,37 +18,53 @@ type ChatMsg struct {
 // EditText edits the text of the message.
 // If the msg is not found, it will send a new message with the given text.
 func (msg *ChatMsg) EditText(text string) error {
+	return msg.EditTextContext(context.Background(), text)
+}
+
+// Same as EditText(), stopping the retries, rate limit waits and HTTP requests when ctx is done.
+func (msg *ChatMsg) EditTextContext(ctx context.Context, text string) error {
 	if msg == nil || msg.Chat == nil {
 		return tgxerrors.ErrMsgNotFound
 	}
 	if msg.Msg == nil {
-		newMsg := tgbotapi.NewMessage(msg.Chat.ChatID, text)
-		newMsg.DisableWebPagePreview = msg.Chat.disableWebPagePreview
-		if msg.Chat.ChatTopic > 0 {
-			newMsg.ReplyToMessageID = msg.Chat.ChatTopic
+		chatID, topic := msg.Chat.decideChatAndTopic(nil)
+		newMsg := tgbotapi.NewMessage(chatID, text)
+		newMsg.DisableWebPagePreview = msg.Chat.webPagePreviewDisabled()
+		if topic > 0 {
+			newMsg.ReplyToMessageID = topic
 		}
-		_, err := msg.Chat.sendWithRetry(newMsg)
+		_, err := msg.Chat.sendWithRetry(ctx, newMsg)
 		return err
 	}
 	msgToEdit := tgbotapi.NewEditMessageText(msg.Msg.Chat.ID, msg.Msg.MessageID, text)
-	msgToEdit.DisableWebPagePreview = msg.Chat.disableWebPagePreview
+	msgToEdit.DisableWebPagePreview = msg.Chat.webPagePreviewDisabled()
 
-	_, err := msg.Chat.sendWithRetry(msgToEdit)
+	_, err := msg.Chat.sendWithRetry(ctx, msgToEdit)
 	return err
 }
 
 func (msg *ChatMsg) ReplaceWith(replacingMsg *tgbotapi.Message) error {
+	return msg.ReplaceWithContext(context.Background(), replacingMsg)
+}
+
+// Same as ReplaceWith(), stopping the retries, rate limit waits and HTTP requests when ctx is done.
+func (msg *ChatMsg) ReplaceWithContext(ctx context.Context, replacingMsg *tgbotapi.Message) error {
 	msgToEdit := tgbotapi.NewEditMessageText(msg.Msg.Chat.ID, msg.Msg.MessageID, fmt.Sprintf("%v", replacingMsg.Text))
-	msgToEdit.DisableWebPagePreview = msg.Chat.disableWebPagePreview
+	msgToEdit.DisableWebPagePreview = msg.Chat.webPagePreviewDisabled()
 	msgToEdit.Entities = replacingMsg.Entities
 
-	_, err := msg.Chat.sendWithRetry(msgToEdit)
+	_, err := msg.Chat.sendWithRetry(ctx, msgToEdit)
 	return err
 }
 
 // This function will only delete the tg msg, but the identifier will still be there.
 // If you want to delete the identifier, use chat.DeleteMsgs(identifier) instead.
 func (msg *ChatMsg) Delete() error {
+	return msg.DeleteContext(context.Background())
+}
+
+// Same as Delete(), stopping the retries and HTTP requests when ctx is done.
+func (msg *ChatMsg) DeleteContext(ctx context.Context) error {
 	if msg == nil || msg.Msg == nil {
 		return nil
 	}
@@ -56,14 +72,19 @@ func (msg *ChatMsg) Delete() error {
 		return tgxerrors.ErrMsgNotFound
 	}
 	msgToDelete := tgbotapi.NewDeleteMessage(msg.Msg.Chat.ID, msg.Msg.MessageID)
+	event, attrs := requestAttrs(msgToDelete)
+	bot := msg.Chat.botWithContext(ctx)
 	// Allow msg not found.
-	err := tgxutils.Retry(func() error {
-		_, e := msg.Chat.Bot.Send(msgToDelete)
-		if e.Error() == "Bad Request: message to delete not found" {
+	err := msg.Chat.retryRequest(ctx, event, attrs, func() error {
+		if e := msg.Chat.waitRateLimit(ctx, msgToDelete); e != nil {
+			return e
+		}
+		_, e := bot.Request(msgToDelete)
+		if e != nil && e.Error() == "Bad Request: message to delete not found" {
 			return nil
 		}
 		return e
-	}, msg.Chat.retry, msg.Chat.retryInterval)
+	}, func() int { return 0 })
 
 	return err
 }
@@ -0,0 +1,409 @@
+package tgx
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/0xVanfer/tgx/tgxerrors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The type of a command argument or flag.
+type ArgType int
+
+const (
+	ArgString ArgType = iota
+	ArgInt
+	ArgFloat
+	ArgBool
+	ArgDuration // Parsed by time.ParseDuration, e.g. "1h30m".
+)
+
+func (t ArgType) String() string {
+	switch t {
+	case ArgInt:
+		return "int"
+	case ArgFloat:
+		return "float"
+	case ArgBool:
+		return "bool"
+	case ArgDuration:
+		return "duration"
+	default:
+		return "string"
+	}
+}
+
+// A positional argument of a command.
+type CommandArg struct {
+	Name     string
+	Type     ArgType
+	Optional bool // Optional args must be declared after the required ones.
+	Rest     bool // Takes the rest of the text as is (flags included), unquoted if it is a single quoted token. Must be the last arg.
+}
+
+// A flag of a command, used as "--name value", "--name=value", or "--name" for bool flags.
+type CommandFlag struct {
+	Name    string
+	Type    ArgType
+	Default string // Parsed the same way as the value passed by the user. Empty means no default.
+}
+
+// A command handled by the chat.
+//
+// The command is matched case-insensitively by its name and aliases.
+// "/name@OtherBot" is ignored, since it is addressed to another bot.
+//
+// The arguments are parsed according to Args and Flags before calling Handle.
+// If they are invalid, the usage is replied to the user and Handle is not called.
+// Commands declaring neither Args nor Flags accept any arguments, use args.Raw() to read them.
+//
+// Description and UsageText are shown in /help, and Description is pushed to the Telegram command menu by SyncCommands().
+type Command struct {
+	Name    string // Only names of 1-32 letters, digits and underscores are pushed to the command menu.
+	Aliases []string
+	Args    []CommandArg
+	Flags   []CommandFlag
+
+	Description  string
+	Descriptions map[string]string // Translations of Description, map[IETF language code, e.g. "ru"] = description.
+	UsageText    string            // Replaces the generated usage line, e.g. "/send <amount> [memo]".
+	Hidden       bool              // Hidden commands still work, but are not listed in /help or the command menu.
+
+	Handle Handler // Use ctx.Args() to read the parsed arguments.
+}
+
+// The parsed arguments and flags of a command.
+// Getters return the zero value if the arg or flag is not given and has no default.
+type CommandArgs struct {
+	raw    string
+	values map[string]any
+}
+
+func (args *CommandArgs) Raw() string { return args.raw }
+
+func (args *CommandArgs) Has(name string) bool {
+	_, exist := args.values[name]
+	return exist
+}
+
+func (args *CommandArgs) String(name string) string {
+	v, _ := args.values[name].(string)
+	return v
+}
+
+func (args *CommandArgs) Int(name string) int64 {
+	v, _ := args.values[name].(int64)
+	return v
+}
+
+func (args *CommandArgs) Float(name string) float64 {
+	v, _ := args.values[name].(float64)
+	return v
+}
+
+func (args *CommandArgs) Bool(name string) bool {
+	v, _ := args.values[name].(bool)
+	return v
+}
+
+func (args *CommandArgs) Duration(name string) time.Duration {
+	v, _ := args.values[name].(time.Duration)
+	return v
+}
+
+// Usage returns the usage line of the command, e.g. "/send <amount:int> [memo] [--dry-run]".
+// If UsageText is set, it is returned instead.
+func (cmd *Command) Usage() string {
+	if cmd.UsageText != "" {
+		return cmd.UsageText
+	}
+	var b strings.Builder
+	b.WriteString("/" + cmd.Name)
+	for _, arg := range cmd.Args {
+		name := arg.Name
+		if arg.Type != ArgString {
+			name += ":" + arg.Type.String()
+		}
+		if arg.Rest {
+			name += "..."
+		}
+		if arg.Optional {
+			b.WriteString(" [" + name + "]")
+		} else {
+			b.WriteString(" <" + name + ">")
+		}
+	}
+	for _, flag := range cmd.Flags {
+		if flag.Type == ArgBool {
+			b.WriteString(" [--" + flag.Name + "]")
+		} else {
+			b.WriteString(" [--" + flag.Name + " <" + flag.Type.String() + ">]")
+		}
+	}
+	return b.String()
+}
+
+// Parse the arguments text of a message, which is msg.CommandArguments().
+func (cmd *Command) parseArgs(raw string) (*CommandArgs, error) {
+	args := &CommandArgs{raw: raw, values: make(map[string]any)}
+	if len(cmd.Args) == 0 && len(cmd.Flags) == 0 {
+		return args, nil
+	}
+
+	for _, flag := range cmd.Flags {
+		if flag.Default == "" {
+			continue
+		}
+		v, err := parseArgValue(flag.Type, flag.Default)
+		if err != nil {
+			return nil, fmt.Errorf("%w: --%s default %q", tgxerrors.ErrCommandInvalidArg, flag.Name, flag.Default)
+		}
+		args.values[flag.Name] = v
+	}
+
+	tokens := tokenizeArgs(raw)
+	argIndex := 0
+	flagsEnded := false
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+
+		if !flagsEnded && !token.quoted && token.text == "--" {
+			flagsEnded = true
+			continue
+		}
+		if !flagsEnded && !token.quoted && strings.HasPrefix(token.text, "--") {
+			name, value, hasValue := strings.Cut(token.text[2:], "=")
+			flag, ok := cmd.findFlag(name)
+			if !ok {
+				return nil, fmt.Errorf("%w: --%s", tgxerrors.ErrCommandUnknownFlag, name)
+			}
+			if !hasValue {
+				if flag.Type == ArgBool {
+					value = "true"
+				} else if i+1 < len(tokens) {
+					i++
+					value = tokens[i].text
+				} else {
+					return nil, fmt.Errorf("%w: --%s", tgxerrors.ErrCommandMissingArg, name)
+				}
+			}
+			v, err := parseArgValue(flag.Type, value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: --%s expects %s, got %q", tgxerrors.ErrCommandInvalidArg, name, flag.Type, value)
+			}
+			args.values[flag.Name] = v
+			continue
+		}
+
+		if argIndex >= len(cmd.Args) {
+			return nil, fmt.Errorf("%w: %q", tgxerrors.ErrCommandTooManyArgs, token.text)
+		}
+		arg := cmd.Args[argIndex]
+		argIndex++
+		if arg.Rest {
+			if token.quoted && i == len(tokens)-1 {
+				args.values[arg.Name] = token.text
+			} else {
+				args.values[arg.Name] = strings.TrimSpace(raw[token.start:])
+			}
+			break
+		}
+		v, err := parseArgValue(arg.Type, token.text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: <%s> expects %s, got %q", tgxerrors.ErrCommandInvalidArg, arg.Name, arg.Type, token.text)
+		}
+		args.values[arg.Name] = v
+	}
+
+	for _, arg := range cmd.Args[argIndex:] {
+		if !arg.Optional {
+			return nil, fmt.Errorf("%w: <%s>", tgxerrors.ErrCommandMissingArg, arg.Name)
+		}
+	}
+	return args, nil
+}
+
+func (cmd *Command) findFlag(name string) (CommandFlag, bool) {
+	for _, flag := range cmd.Flags {
+		if strings.EqualFold(flag.Name, name) {
+			return flag, true
+		}
+	}
+	return CommandFlag{}, false
+}
+
+func parseArgValue(t ArgType, value string) (any, error) {
+	switch t {
+	case ArgInt:
+		return strconv.ParseInt(value, 10, 64)
+	case ArgFloat:
+		return strconv.ParseFloat(value, 64)
+	case ArgBool:
+		return strconv.ParseBool(value)
+	case ArgDuration:
+		return time.ParseDuration(value)
+	default:
+		return value, nil
+	}
+}
+
+type argToken struct {
+	text   string
+	start  int  // Byte offset of the token in the raw text.
+	quoted bool // Quoted tokens are never treated as flags.
+}
+
+// Split the text by spaces. A quote at the start of a token keeps the text up to the closing quote as a single token,
+// an unterminated quote runs to the end. Quotes inside a token are kept, e.g. don't.
+// Telegram clients may replace "" with “”, so both are accepted.
+// A backslash escapes the following quote, space or backslash, other backslashes are kept, e.g. C:\dir.
+func tokenizeArgs(raw string) (tokens []argToken) {
+	var (
+		current strings.Builder
+		inToken bool
+		quote   rune
+		escaped bool
+		token   argToken
+	)
+	for i, r := range raw {
+		if escaped {
+			escaped = false
+			if isEscapable(r) {
+				current.WriteRune(r)
+				continue
+			}
+			current.WriteRune('\\')
+		}
+		if r == '\\' {
+			if !inToken {
+				token = argToken{start: i}
+				inToken = true
+			}
+			escaped = true
+			continue
+		}
+		switch {
+		case quote != 0:
+			if r == quote || (quote == '“' && r == '”') {
+				quote = 0
+				continue
+			}
+			current.WriteRune(r)
+		case unicode.IsSpace(r):
+			if inToken {
+				token.text = current.String()
+				tokens = append(tokens, token)
+				current.Reset()
+				inToken = false
+			}
+		default:
+			if !inToken {
+				token = argToken{start: i}
+				inToken = true
+			}
+			if current.Len() == 0 && (r == '"' || r == '\'' || r == '“') {
+				quote = r
+				token.quoted = true
+				continue
+			}
+			current.WriteRune(r)
+		}
+	}
+	if escaped {
+		current.WriteRune('\\')
+	}
+	if inToken {
+		token.text = current.String()
+		tokens = append(tokens, token)
+	}
+	return
+}
+
+func isEscapable(r rune) bool {
+	return r == '"' || r == '\'' || r == '“' || r == '”' || r == '\\' || unicode.IsSpace(r)
+}
+
+// ========== Chat ==========
+
+// Register a command with typed arguments and flags.
+// Registering a command with an existing name or alias replaces it.
+func (chat *Chat) RegisterCommand(cmd Command) {
+	chat.handlersMu.Lock()
+	defer chat.onHandlerRegistered()
+	defer chat.handlersMu.Unlock()
+
+	if chat.commands == nil {
+		chat.commands = make(map[string]*Command)
+	}
+	if chat.commandAliases == nil {
+		chat.commandAliases = make(map[string]string)
+	}
+	name := strings.ToLower(cmd.Name)
+	handleFunc := cmd.Handle
+	cmd.Handle = func(ctx *Context) (err error) {
+		defer chat.recoverHandler("command", name, &err)
+		return handleFunc(ctx)
+	}
+	chat.commands[name] = &cmd
+	for alias, aliasOf := range chat.commandAliases {
+		if aliasOf == name {
+			delete(chat.commandAliases, alias)
+		}
+	}
+	for _, alias := range cmd.Aliases {
+		chat.commandAliases[strings.ToLower(alias)] = name
+	}
+}
+
+// Unregister the command by name, together with its aliases. Nothing happens if it is not registered.
+func (chat *Chat) UnregisterHandleCommand(command string) {
+	chat.handlersMu.Lock()
+	defer chat.handlersMu.Unlock()
+
+	name := strings.ToLower(command)
+	delete(chat.commands, name)
+	for alias, aliasOf := range chat.commandAliases {
+		if aliasOf == name {
+			delete(chat.commandAliases, alias)
+		}
+	}
+}
+
+// Find the command by name or alias, case-insensitively.
+func (chat *Chat) findCommand(name string) (*Command, bool) {
+	chat.handlersMu.RLock()
+	defer chat.handlersMu.RUnlock()
+
+	name = strings.ToLower(name)
+	if cmd, exist := chat.commands[name]; exist {
+		return cmd, true
+	}
+	if alias, exist := chat.commandAliases[name]; exist {
+		cmd, exist := chat.commands[alias]
+		return cmd, exist
+	}
+	return nil, false
+}
+
+// Whether the command is addressed to this bot. "/cmd" is addressed to every bot in the chat.
+// Commands to any bot are taken as addressed to this one if its username is unknown, e.g. lazily registered and not connected yet.
+func (chat *Chat) isCommandToMe(msg *tgbotapi.Message) bool {
+	_, botName, found := strings.Cut(msg.CommandWithAt(), "@")
+	if !found || chat.Bot == nil {
+		return true
+	}
+	userName := chat.Bot.Self().UserName
+	return userName == "" || strings.EqualFold(botName, userName)
+}
+
+// Reply the usage error to the chat and topic the command was sent to.
+func (chat *Chat) replyUsage(ctx context.Context, msg *tgbotapi.Message, cmd *Command, usageErr error) error {
+	text := fmt.Sprintf("%s\nUsage: %s", strings.TrimPrefix(usageErr.Error(), "tgx: "), cmd.Usage())
+	_, err := chat.SendTextMsgContext(ctx, chat.GetOverrideInfoFromMsg(msg), text)
+	return err
+}
@@ -0,0 +1,556 @@
+package tgx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xVanfer/tgx/tgxerrors"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The prefix of the environment variables read by LoadConfig().
+const ConfigEnvPrefix = "TGX_"
+
+// Config is the configuration of a wrapper and its chats, see LoadConfig() and InitWithConfig().
+//
+// Durations are strings like "1.5s", or numbers of seconds.
+type Config struct {
+	Chats []SingleChatConf `json:"chats" mapstructure:"chats"`
+
+	// Applied to every chat, nil to keep the defaults. See Chat.SetRetry() etc.
+	Retry                 *int           `json:"retry,omitempty" mapstructure:"retry"`
+	RetryInterval         *time.Duration `json:"retry_interval,omitempty" mapstructure:"retry_interval"`
+	RetryMaxInterval      *time.Duration `json:"retry_max_interval,omitempty" mapstructure:"retry_max_interval"`
+	MaxFloodWait          *time.Duration `json:"max_flood_wait,omitempty" mapstructure:"max_flood_wait"`
+	DisableWebPagePreview *bool          `json:"disable_web_page_preview,omitempty" mapstructure:"disable_web_page_preview"`
+
+	// Settings of the wrapper. See TgWrapper.SetRateLimits() etc.
+	RateLimits       *RateLimits `json:"rate_limits,omitempty" mapstructure:"rate_limits"`
+	APIEndpoint      string      `json:"api_endpoint,omitempty" mapstructure:"api_endpoint"`
+	FileEndpoint     string      `json:"file_endpoint,omitempty" mapstructure:"file_endpoint"`
+	LazyRegistration bool        `json:"lazy_registration,omitempty" mapstructure:"lazy_registration"`
+}
+
+// LoadConfig reads the config from the JSON, YAML or TOML file (by the extension) and the environment variables,
+// and validates it without calling Telegram. path "" to read the environment variables only.
+//
+// Environment variables named by ConfigEnvPrefix and the upper case keys override the file,
+// e.g. TGX_RETRY=5, TGX_RATE_LIMITS_BOT_COUNT=10, TGX_CHATS_0_BOT_TOKEN=123:abc.
+//
+// String values can refer to secrets: "${env:TG_TOKEN}" is replaced by the environment variable,
+// "${file:/run/secrets/tg_token}" by the content of the file without the trailing newline.
+// Relative file paths are relative to the config file.
+//
+// The errors of the entries are *tgxerrors.ConfigError, joined by errors.Join().
+func LoadConfig(path string) (*Config, error) {
+	raw := make(map[string]any)
+	dir := "."
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = parseConfig(content, filepath.Ext(path)); err != nil {
+			return nil, fmt.Errorf("tgx: parse config %s: %w", path, err)
+		}
+		dir = filepath.Dir(path)
+	}
+
+	configType := reflect.TypeFor[Config]()
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if name, ok := strings.CutPrefix(name, ConfigEnvPrefix); ok {
+			setConfigEnv(raw, configType, name, value)
+		}
+	}
+
+	expanded, err := expandConfigSecrets(raw, "", dir)
+	if err != nil {
+		return nil, err
+	}
+	conf := &Config{}
+	if err = decodeConfigValue(expanded, reflect.ValueOf(conf).Elem(), ""); err != nil {
+		return nil, err
+	}
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Validate the config without calling Telegram, returning all invalid entries joined by errors.Join().
+func (conf *Config) Validate() error {
+	var errs []error
+	invalid := func(path string, err error) {
+		errs = append(errs, &tgxerrors.ConfigError{Path: path, Err: err})
+	}
+
+	identifiers := make(map[string]int)
+	for i, chat := range conf.Chats {
+		path := fmt.Sprintf("chats[%d]", i)
+		if chat.Identifier == "" {
+			invalid(path+".identifier", tgxerrors.ErrIdentifierEmpty)
+		} else if j, exist := identifiers[chat.Identifier]; exist {
+			invalid(path+".identifier", fmt.Errorf("%w: %q is used by chats[%d]", tgxerrors.ErrIdentifierAlreadyExists, chat.Identifier, j))
+		} else {
+			identifiers[chat.Identifier] = i
+		}
+		if chat.Bot == nil {
+			if chat.BotToken == "" {
+				invalid(path+".bot_token", tgxerrors.ErrEmptyBotToken)
+			} else if !botTokenRegexp.MatchString(chat.BotToken) {
+				invalid(path+".bot_token", tgxerrors.ErrInvalidBotToken)
+			}
+		}
+		if chat.ChatTopic < -1 {
+			invalid(path+".chat_topic", fmt.Errorf("%d is not a topic, use -1 for the entire chat", chat.ChatTopic))
+		}
+		if _, err := chat.resolveLink(); err != nil {
+			invalid(path+".link", err)
+		}
+		if err := validateEndpoint(chat.APIEndpoint); err != nil {
+			invalid(path+".api_endpoint", err)
+		}
+		if err := validateEndpoint(chat.FileEndpoint); err != nil {
+			invalid(path+".file_endpoint", err)
+		}
+	}
+
+	if conf.Retry != nil && *conf.Retry < 0 {
+		invalid("retry", fmt.Errorf("%d is negative", *conf.Retry))
+	}
+	for path, d := range map[string]*time.Duration{
+		"retry_interval":     conf.RetryInterval,
+		"retry_max_interval": conf.RetryMaxInterval,
+		"max_flood_wait":     conf.MaxFloodWait,
+	} {
+		if d != nil && *d < 0 {
+			invalid(path, fmt.Errorf("%s is negative", *d))
+		}
+	}
+	if conf.RateLimits != nil {
+		for path, rate := range map[string]Rate{
+			"rate_limits.bot":     conf.RateLimits.Bot,
+			"rate_limits.private": conf.RateLimits.Private,
+			"rate_limits.group":   conf.RateLimits.Group,
+		} {
+			if rate.Count < 0 || rate.Per < 0 || (rate.Count > 0 && rate.Per == 0) {
+				invalid(path, fmt.Errorf("%d per %s is not a rate", rate.Count, rate.Per))
+			}
+		}
+	}
+	if err := validateEndpoint(conf.APIEndpoint); err != nil {
+		invalid("api_endpoint", err)
+	}
+	if err := validateEndpoint(conf.FileEndpoint); err != nil {
+		invalid("file_endpoint", err)
+	}
+
+	// The maps above are iterated randomly.
+	sortConfigErrors(errs)
+	return errors.Join(errs...)
+}
+
+// InitWithConfig creates the wrapper with the settings of the config, and registers its chats.
+// Use TgWrapper.ApplyConfig() or TgWrapper.WatchConfig() to apply the changes of the config later.
+func InitWithConfig(conf *Config) (tg *TgWrapper, err error) {
+	tg = &TgWrapper{lazy: conf.LazyRegistration}
+	tg.SetAPIEndpoint(conf.APIEndpoint)
+	tg.SetFileEndpoint(conf.FileEndpoint)
+
+	if err = tg.ApplyConfig(conf); err != nil {
+		return nil, err
+	}
+	return tg, nil
+}
+
+// Apply the chat level settings of the config to the chat.
+func (conf *Config) applyTo(chat *Chat) {
+	if conf.Retry != nil {
+		chat.SetRetry(*conf.Retry)
+	}
+	if conf.RetryInterval != nil {
+		chat.SetRetryInterval(*conf.RetryInterval)
+	}
+	if conf.RetryMaxInterval != nil {
+		chat.SetRetryMaxInterval(*conf.RetryMaxInterval)
+	}
+	if conf.MaxFloodWait != nil {
+		chat.SetMaxFloodWait(*conf.MaxFloodWait)
+	}
+	if conf.DisableWebPagePreview != nil {
+		chat.SetDisableWebPagePreview(*conf.DisableWebPagePreview)
+	}
+}
+
+// ========== Internal ==========
+
+func parseConfig(content []byte, ext string) (raw map[string]any, err error) {
+	switch strings.ToLower(ext) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		err = decoder.Decode(&raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &raw)
+	case ".toml":
+		err = toml.Unmarshal(content, &raw)
+		normalizeTOML(raw)
+	default:
+		return nil, fmt.Errorf("unknown config format %q, should be .json, .yaml, .yml or .toml", ext)
+	}
+	if raw == nil {
+		raw = make(map[string]any)
+	}
+	return raw, err
+}
+
+// TOML decodes arrays of tables as []map[string]any, convert them to []any like JSON and YAML.
+func normalizeTOML(raw map[string]any) {
+	for key, value := range raw {
+		switch v := value.(type) {
+		case map[string]any:
+			normalizeTOML(v)
+		case []map[string]any:
+			list := make([]any, len(v))
+			for i, item := range v {
+				normalizeTOML(item)
+				list[i] = item
+			}
+			raw[key] = list
+		}
+	}
+}
+
+func validateEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	// Formats like "http://localhost:8081/bot%s/%s" are valid too.
+	u, err := url.Parse(strings.ReplaceAll(endpoint, "%s", "s"))
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", endpoint)
+	}
+	return nil
+}
+
+func sortConfigErrors(errs []error) {
+	slices.SortFunc(errs, func(a, b error) int {
+		return strings.Compare(a.Error(), b.Error())
+	})
+}
+
+// Set the environment variable into raw, following the json keys of t,
+// e.g. "CHATS_0_BOT_TOKEN" sets raw["chats"][0]["bot_token"]. Returns false if no key matches.
+func setConfigEnv(raw map[string]any, t reflect.Type, name string, value string) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for i := range t.NumField() {
+		key := configKey(t.Field(i))
+		if key == "" {
+			continue
+		}
+		fieldType := t.Field(i).Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		upper := strings.ToUpper(key)
+
+		switch {
+		case fieldType.Kind() == reflect.Struct:
+			rest, ok := strings.CutPrefix(name, upper+"_")
+			if !ok {
+				continue
+			}
+			sub, ok := raw[key].(map[string]any)
+			if !ok {
+				sub = make(map[string]any)
+			}
+			if setConfigEnv(sub, fieldType, rest, value) {
+				raw[key] = sub
+				return true
+			}
+		case fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Struct:
+			rest, ok := strings.CutPrefix(name, upper+"_")
+			if !ok {
+				continue
+			}
+			indexStr, rest, _ := strings.Cut(rest, "_")
+			index, err := strconv.Atoi(indexStr)
+			if err != nil || index < 0 || index > 1000 {
+				continue
+			}
+			list, _ := raw[key].([]any)
+			for len(list) <= index {
+				list = append(list, make(map[string]any))
+			}
+			item, ok := list[index].(map[string]any)
+			if !ok {
+				item = make(map[string]any)
+			}
+			if setConfigEnv(item, fieldType.Elem(), rest, value) {
+				list[index] = item
+				raw[key] = list
+				return true
+			}
+		case name == upper:
+			raw[key] = value
+			return true
+		}
+	}
+	return false
+}
+
+var configSecretRegexp = regexp.MustCompile(`\$\{(\w+):([^}]*)\}`)
+
+// Replace the references to secrets in the strings of the raw config.
+func expandConfigSecrets(raw any, path string, dir string) (any, error) {
+	switch v := raw.(type) {
+	case string:
+		var errs []error
+		expanded := configSecretRegexp.ReplaceAllStringFunc(v, func(ref string) string {
+			match := configSecretRegexp.FindStringSubmatch(ref)
+			secret, err := readConfigSecret(match[1], match[2], dir)
+			if err != nil {
+				errs = append(errs, &tgxerrors.ConfigError{Path: path, Err: err})
+			}
+			return secret
+		})
+		return expanded, errors.Join(errs...)
+	case map[string]any:
+		var errs []error
+		for key, value := range v {
+			expanded, err := expandConfigSecrets(value, joinConfigPath(path, key), dir)
+			errs = append(errs, err)
+			v[key] = expanded
+		}
+		return v, errors.Join(errs...)
+	case []any:
+		var errs []error
+		for i, value := range v {
+			expanded, err := expandConfigSecrets(value, fmt.Sprintf("%s[%d]", path, i), dir)
+			errs = append(errs, err)
+			v[i] = expanded
+		}
+		return v, errors.Join(errs...)
+	default:
+		return raw, nil
+	}
+}
+
+func readConfigSecret(kind string, name string, dir string) (string, error) {
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case "file":
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	default:
+		return "", fmt.Errorf("unknown secret reference ${%s:%s}, should be ${env:...} or ${file:...}", kind, name)
+	}
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// Decode the raw config into out by the json keys, converting the strings of the environment variables.
+func decodeConfigValue(raw any, out reflect.Value, path string) error {
+	if raw == nil {
+		return nil
+	}
+	invalid := func() error {
+		return &tgxerrors.ConfigError{Path: path, Err: fmt.Errorf("%v is not a %s", raw, out.Type())}
+	}
+
+	if out.Kind() == reflect.Pointer {
+		if out.IsNil() {
+			out.Set(reflect.New(out.Type().Elem()))
+		}
+		return decodeConfigValue(raw, out.Elem(), path)
+	}
+	if out.Type() == durationType {
+		switch v := raw.(type) {
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				if seconds, e := strconv.ParseFloat(v, 64); e == nil {
+					d, err = time.Duration(seconds*float64(time.Second)), nil
+				}
+			}
+			if err != nil {
+				return invalid()
+			}
+			out.SetInt(int64(d))
+			return nil
+		default:
+			seconds, ok := configNumber(raw)
+			if !ok {
+				return invalid()
+			}
+			out.SetInt(int64(seconds * float64(time.Second)))
+			return nil
+		}
+	}
+
+	switch out.Kind() {
+	case reflect.String:
+		switch v := raw.(type) {
+		case string:
+			out.SetString(v)
+		case json.Number:
+			out.SetString(v.String())
+		case int, int64, uint64, float64, bool:
+			out.SetString(fmt.Sprint(v))
+		default:
+			return invalid()
+		}
+	case reflect.Bool:
+		switch v := raw.(type) {
+		case bool:
+			out.SetBool(v)
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return invalid()
+			}
+			out.SetBool(b)
+		default:
+			return invalid()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch v := raw.(type) {
+		case string:
+			parsed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return invalid()
+			}
+			n = parsed
+		default:
+			f, ok := configNumber(raw)
+			if !ok || f != math.Trunc(f) {
+				return invalid()
+			}
+			n = int64(f)
+			if i, ok := raw.(int64); ok {
+				n = i // Large IDs may not fit in float64.
+			}
+			if number, ok := raw.(json.Number); ok {
+				if i, err := number.Int64(); err == nil {
+					n = i
+				}
+			}
+		}
+		if out.OverflowInt(n) {
+			return invalid()
+		}
+		out.SetInt(n)
+	case reflect.Slice:
+		var list []any
+		switch v := raw.(type) {
+		case []any:
+			list = v
+		default:
+			return invalid()
+		}
+		slice := reflect.MakeSlice(out.Type(), len(list), len(list))
+		var errs []error
+		for i, item := range list {
+			errs = append(errs, decodeConfigValue(item, slice.Index(i), fmt.Sprintf("%s[%d]", path, i)))
+		}
+		out.Set(slice)
+		return errors.Join(errs...)
+	case reflect.Struct:
+		m, ok := raw.(map[string]any)
+		if !ok {
+			return invalid()
+		}
+		fields := make(map[string]int)
+		for i := range out.NumField() {
+			if key := configKey(out.Type().Field(i)); key != "" {
+				fields[key] = i
+			}
+		}
+		var errs []error
+		for key, value := range m {
+			i, ok := fields[key]
+			if !ok {
+				errs = append(errs, &tgxerrors.ConfigError{Path: joinConfigPath(path, key), Err: errors.New("unknown key")})
+				continue
+			}
+			if err := decodeConfigValue(value, out.Field(i), joinConfigPath(path, key)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		sortConfigErrors(errs)
+		return errors.Join(errs...)
+	default:
+		return invalid()
+	}
+	return nil
+}
+
+func configNumber(raw any) (float64, bool) {
+	switch v := raw.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// The json key of the field, "" if not in the config.
+func configKey(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if key == "-" {
+		return ""
+	}
+	if key == "" {
+		return strings.ToLower(field.Name)
+	}
+	return key
+}
+
+func joinConfigPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,116 @@
+package tgx
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/0xVanfer/tgx/tgxerrors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Context is passed to every handler.
+// It holds the update, the chat matching the update and the bot receiving it,
+// with helpers replying to the chat and topic the update comes from.
+type Context struct {
+	context.Context
+
+	Update *Update
+	Chat   *Chat
+	Bot    BotAPI
+
+	// The parsed command arguments, only set for commands.
+	args *CommandArgs
+
+	// The regex match and its capture group names, set by the Regex filter.
+	matches    []string
+	matchNames []string
+}
+
+func (chat *Chat) newContext(ctx context.Context, update *Update) *Context {
+	return &Context{
+		Context: ctx,
+		Update:  update,
+		Chat:    chat,
+		Bot:     chat.Bot,
+	}
+}
+
+// Msg returns the message of the update, nil if the update has no message (e.g. chat member updates).
+func (ctx *Context) Msg() *tgbotapi.Message { return ctx.Update.Msg() }
+
+// Args returns the parsed command arguments.
+// For handlers other than commands, returns empty args with the text of the message as Raw().
+func (ctx *Context) Args() *CommandArgs {
+	if ctx.args != nil {
+		return ctx.args
+	}
+	args := &CommandArgs{values: make(map[string]any)}
+	if msg := ctx.Msg(); msg != nil {
+		args.raw = msg.Text
+	}
+	return args
+}
+
+// Target returns the chat and topic the update comes from.
+//
+// Poll answers do not belong to any chat, the private chat with the user is returned for them.
+func (ctx *Context) Target() *ChatAndTopic {
+	if fromChat := ctx.Update.FromChat(); fromChat != nil {
+		return &ChatAndTopic{
+			ChatID:    fromChat.ID,
+			ChatTopic: ctx.Update.Topic(),
+		}
+	}
+	if from := ctx.Update.SentFrom(); from != nil {
+		return &ChatAndTopic{ChatID: from.ID}
+	}
+	return nil
+}
+
+// Reply sends the text to the chat and topic the update comes from.
+func (ctx *Context) Reply(text string) (msgsSent []*tgbotapi.Message, err error) {
+	return ctx.Chat.SendTextMsgContext(ctx, ctx.Target(), text)
+}
+
+// ReplyComponents sends the components to the chat and topic the update comes from.
+func (ctx *Context) ReplyComponents(components ...[]MsgComponent) (msgsSent []*tgbotapi.Message, err error) {
+	return ctx.Chat.SendTextMsgByComponentsContext(ctx, ctx.Target(), components...)
+}
+
+// Edit edits the text of a message in the chat the update comes from, e.g. a reply sent before.
+func (ctx *Context) Edit(msgID int, text string) error {
+	target := ctx.Target()
+	if target == nil {
+		return tgxerrors.ErrMsgNotFound
+	}
+	msgToEdit := tgbotapi.NewEditMessageText(target.ChatID, msgID, text)
+	msgToEdit.DisableWebPagePreview = ctx.Chat.webPagePreviewDisabled()
+	_, err := ctx.Chat.sendWithRetry(ctx, msgToEdit)
+	return err
+}
+
+// Delete deletes the message of the update.
+func (ctx *Context) Delete() error {
+	return ctx.Chat.DeleteMsgContext(ctx, ctx.Msg())
+}
+
+// Ack reacts to the message of the update with the emoji, to let the user know it is received.
+// If emoji is "", "👍" is used. The emoji must be one of the reactions allowed by Telegram.
+func (ctx *Context) Ack(emoji string) error {
+	msg := ctx.Msg()
+	if msg == nil || msg.Chat == nil {
+		return tgxerrors.ErrMsgNotFound
+	}
+	if emoji == "" {
+		emoji = "👍"
+	}
+	reaction, err := json.Marshal([]ReactionType{{Type: "emoji", Emoji: emoji}})
+	if err != nil {
+		return err
+	}
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", msg.Chat.ID)
+	params.AddNonZero("message_id", msg.MessageID)
+	params["reaction"] = string(reaction)
+	return ctx.Chat.makeRequestWithRetry(ctx, "setMessageReaction", params)
+}
@@ -0,0 +1,133 @@
+package tgx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/0xVanfer/tgx/tgxerrors"
+)
+
+// How updates are kept in order when processed concurrently.
+type UpdateOrdering int
+
+const (
+	OrderByChat UpdateOrdering = iota // Updates from the same chat are processed in order.
+	OrderByUser                       // Updates from the same user are processed in order, even across chats.
+)
+
+// Set the number of workers processing updates of each bot concurrently, default 1 (one by one).
+//
+// Updates are assigned to workers by chat or user (see SetUpdateOrdering()),
+// so updates of the same chat or user are always processed in order.
+// Should be set before Monitor().
+func (tg *TgWrapper) SetWorkers(workers int) { tg.workers = workers }
+
+// Set the max number of updates waiting for each worker, default 100.
+// When the queue is full, getting new updates waits until there is room, leaving them buffered by Telegram.
+// Should be set before Monitor().
+func (tg *TgWrapper) SetQueueSize(size int) { tg.queueSize = size }
+
+// Set how updates are kept in order when processed concurrently, default OrderByChat.
+// Should be set before Monitor().
+func (tg *TgWrapper) SetUpdateOrdering(ordering UpdateOrdering) { tg.ordering = ordering }
+
+// Set the max time a handler may take, 0 (default) for no limit.
+//
+// The context of the handler is cancelled after the timeout, and the handler is considered failed with ErrHandlerTimeout.
+// The handler keeps running until it returns, and the next updates of its worker wait for it, so the updates stay in order.
+// Handlers should watch ctx.Done() to stop early.
+func (tg *TgWrapper) SetHandlerTimeout(timeout time.Duration) { tg.handlerTimeout = timeout }
+
+// Workers processing the updates of a bot.
+// Each worker has its own queue, updates with the same key always go to the same worker, so they are kept in order.
+type dispatcher struct {
+	queues []chan func()
+	done   chan struct{}
+}
+
+func newDispatcher(workers int, queueSize int) *dispatcher {
+	workers = max(workers, 1)
+	if queueSize <= 0 {
+		queueSize = 100
+	}
+	d := &dispatcher{
+		queues: make([]chan func(), workers),
+		done:   make(chan struct{}, workers),
+	}
+	for i := range d.queues {
+		d.queues[i] = make(chan func(), queueSize)
+		go func(queue chan func()) {
+			for job := range queue {
+				job()
+			}
+			d.done <- struct{}{}
+		}(d.queues[i])
+	}
+	return d
+}
+
+// Queue the job to the worker of the key. Waits if the queue of the worker is full.
+func (d *dispatcher) dispatch(key int64, job func()) {
+	index := uint64(key) % uint64(len(d.queues))
+	d.queues[index] <- job
+}
+
+// Stop the workers after the queued jobs are done, and wait for them.
+func (d *dispatcher) close() {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	for range d.queues {
+		<-d.done
+	}
+}
+
+// The key keeping updates in order.
+func (tg *TgWrapper) orderingKey(update *Update) int64 {
+	fromChat, from := update.FromChat(), update.SentFrom()
+	if tg.ordering == OrderByUser && from != nil {
+		return from.ID
+	}
+	if fromChat != nil {
+		return fromChat.ID
+	}
+	if from != nil {
+		return from.ID
+	}
+	return 0
+}
+
+// Run the handler with the handler timeout of the wrapper.
+func (chat *Chat) runWithTimeout(ctx *Context, handler Handler) error {
+	if chat.wrapper == nil || chat.wrapper.handlerTimeout <= 0 {
+		return chat.runRecovered(ctx, handler)
+	}
+	timeout := chat.wrapper.handlerTimeout
+	parent := ctx.Context
+	timeoutCtx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	ctx.Context = timeoutCtx
+
+	errCh := make(chan error, 1)
+	done := dispatchStateFrom(parent).track()
+	go func() {
+		defer done()
+		errCh <- chat.runRecovered(ctx, handler)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-timeoutCtx.Done():
+		if err := parent.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s", tgxerrors.ErrHandlerTimeout, timeout)
+	}
+}
+
+// Run the handler, recovering the panics of middlewares, which are outside the recover of the handler itself.
+func (chat *Chat) runRecovered(ctx *Context, handler Handler) (err error) {
+	defer chat.recoverHandler("middleware", "", &err)
+	return handler(ctx)
+}
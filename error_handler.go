@@ -0,0 +1,191 @@
+package tgx
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xVanfer/tgx/tgxerrors"
+)
+
+// ErrorHandler is called with the error of a handler when monitoring, after the error is logged.
+// ctx holds the update and the chat, e.g. ctx.Reply() can be used to tell the user something went wrong.
+//
+// handler is the funcIdentifier of the handler, or "/command" for commands.
+// Panics of handlers, middlewares and filters are passed as *PanicError, use errors.As() to get the stack.
+type ErrorHandler func(ctx *Context, handler string, err error)
+
+// PanicError is the error of a handler panicking, with the stack of the panic.
+type PanicError struct {
+	Kind    string // "command", "msg", "middleware", "filter", or the update kind.
+	Handler string
+	Value   any
+	Stack   []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("tgx: handle %s [%s] panic: %v", e.Kind, e.Handler, e.Value)
+}
+
+// Set the error handler of the wrapper, used by all chats without their own error handler.
+func (tg *TgWrapper) SetErrorHandler(handler ErrorHandler) { tg.errorHandler = handler }
+
+// Set the error handler of the chat, overriding the one of the wrapper.
+func (chat *Chat) SetErrorHandler(handler ErrorHandler) { chat.errorHandler = handler }
+
+// Set the store receiving the updates failed to handle when monitoring. Use Replay() to handle them again.
+func (tg *TgWrapper) SetDeadLetterStore(store DeadLetterStore) { tg.deadLetters = store }
+
+// Report the error of a handler to the logger, the error handler and the dead letter store.
+func (chat *Chat) reportHandlerError(ctx context.Context, update *Update, handler string, err error) {
+	chat.logHandlerError(update, handler, err)
+
+	errorHandler := chat.errorHandler
+	if errorHandler == nil && chat.wrapper != nil {
+		errorHandler = chat.wrapper.errorHandler
+	}
+	if errorHandler != nil {
+		func() {
+			// A panicking error handler must not stop monitoring.
+			defer func() {
+				if r := recover(); r != nil {
+					chat.getLogger().Error("tgx: error handler panic", append(chat.logAttrs(), slog.String("panic", chat.redactPanic(r)))...)
+				}
+			}()
+			errorHandler(chat.newContext(ctx, update), handler, err)
+		}()
+	}
+
+	if chat.wrapper != nil && chat.wrapper.deadLetters != nil {
+		letter := DeadLetter{
+			Chat:    chat.Identifier,
+			Handler: handler,
+			Update:  update,
+			Error:   chat.redact(err),
+			Time:    time.Now(),
+		}
+		if e := chat.wrapper.deadLetters.Push(letter); e != nil {
+			chat.getLogger().Error("tgx: push dead letter failed", append(chat.logAttrs(), slog.String("error", e.Error()))...)
+		}
+	}
+}
+
+// Recover the panic of a handler as a *PanicError.
+// Must be called by defer.
+func (chat *Chat) recoverHandler(kind string, identifier string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	*err = &PanicError{Kind: kind, Handler: identifier, Value: r, Stack: debug.Stack()}
+	chat.getLogger().Error("tgx: handler panic", append(chat.logAttrs(), slog.String("handler", identifier), slog.String("panic", chat.redactPanic(r)))...)
+}
+
+// ========== Dead Letters ==========
+
+// An update failed to handle.
+type DeadLetter struct {
+	Chat    string    `json:"chat"`    // The identifier of the chat.
+	Handler string    `json:"handler"` // The funcIdentifier of the handler, or "/command" for commands.
+	Update  *Update   `json:"update"`
+	Error   string    `json:"error"`
+	Time    time.Time `json:"time"`
+}
+
+// DeadLetterStore keeps the updates failed to handle, e.g. in a database, for later replay.
+type DeadLetterStore interface {
+	Push(letter DeadLetter) error
+}
+
+// Replay runs the failed handler of the dead letter again, with the same update.
+// Only the failed handler is run, other handlers of the update are not run again.
+func (tg *TgWrapper) Replay(letter DeadLetter) error {
+	chat, err := tg.GetChat(letter.Chat)
+	if err != nil {
+		return err
+	}
+	if letter.Update == nil {
+		return tgxerrors.ErrMsgNotFound
+	}
+	ctx := withDispatchState(context.Background())
+
+	if command, isCommand := strings.CutPrefix(letter.Handler, "/"); isCommand {
+		if msg := letter.Update.Message; msg == nil || !strings.EqualFold(msg.Command(), command) {
+			return tgxerrors.ErrHandlerNotFound
+		}
+		if _, exist := chat.findCommand(command); !exist && strings.EqualFold(command, helpCommandName) {
+			return tg.replayHelp(ctx, chat, letter.Update)
+		}
+		return chat.handleCommand(ctx, letter.Update)
+	}
+
+	chat.handlersMu.RLock()
+	handlers := chat.handleMsgFuncs
+	if kind := letter.Update.Kind(); kind != UpdateKindMessage {
+		handlers = chat.handleUpdateFuncs[kind]
+	}
+	var h *registeredHandler
+	for _, registered := range handlers {
+		if registered.identifier == letter.Handler {
+			h = registered
+		}
+	}
+	chat.handlersMu.RUnlock()
+
+	if h == nil {
+		return tgxerrors.ErrHandlerNotFound
+	}
+	return chat.runHandler(ctx, letter.Update, h)
+}
+
+// Reply the built-in /help again. ErrHandlerNotFound if it is not replied anymore,
+// e.g. the help is disabled, or the chats of the bot have no commands.
+func (tg *TgWrapper) replayHelp(ctx context.Context, chat *Chat, update *Update) error {
+	var b *botInfo
+	if chat.Bot != nil {
+		b = tg.getBotInfo(chat.Bot.Token())
+	}
+	if b == nil {
+		return tgxerrors.ErrHandlerNotFound
+	}
+	replied, err := b.handleHelp(ctx, update)
+	if replied == nil {
+		return tgxerrors.ErrHandlerNotFound
+	}
+	return err
+}
+
+// MemoryDeadLetterStore keeps the latest dead letters in memory.
+type MemoryDeadLetterStore struct {
+	mu       sync.Mutex
+	capacity int
+	letters  []DeadLetter
+}
+
+// Keep at most capacity dead letters, the oldest ones are dropped when full. 0 for no limit.
+func NewMemoryDeadLetterStore(capacity int) *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{capacity: capacity}
+}
+
+func (store *MemoryDeadLetterStore) Push(letter DeadLetter) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.letters = append(store.letters, letter)
+	if store.capacity > 0 && len(store.letters) > store.capacity {
+		store.letters = store.letters[len(store.letters)-store.capacity:]
+	}
+	return nil
+}
+
+// Drain returns all dead letters and removes them from the store.
+func (store *MemoryDeadLetterStore) Drain() []DeadLetter {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	letters := store.letters
+	store.letters = nil
+	return letters
+}
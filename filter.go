@@ -0,0 +1,200 @@
+package tgx
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Filter decides whether a handler should handle the update.
+// Filters are attached when registering a handler by WithFilter(), and can be combined by And(), Or() and Not().
+//
+// Filters about messages (all in this file except InChat and FromUser) never match updates without a message.
+// The text of a message is its caption if it has no text, e.g. photos.
+type Filter func(ctx *Context) bool
+
+// WithFilter makes the handler only handle the updates matching all the filters.
+// Middlewares are not run for the updates not matching.
+func WithFilter(filters ...Filter) HandlerOption {
+	return func(h *registeredHandler) {
+		if h.filter != nil {
+			filters = append([]Filter{h.filter}, filters...)
+		}
+		h.filter = And(filters...)
+	}
+}
+
+// And matches if all filters match. Empty And matches everything.
+func And(filters ...Filter) Filter {
+	return func(ctx *Context) bool {
+		for _, filter := range filters {
+			if !filter(ctx) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or matches if any filter matches, filters after the first matching one are not checked. Empty Or matches nothing.
+func Or(filters ...Filter) Filter {
+	return func(ctx *Context) bool {
+		for _, filter := range filters {
+			if filter(ctx) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func Not(filter Filter) Filter {
+	return func(ctx *Context) bool { return !filter(ctx) }
+}
+
+// Regex matches the text against the pattern. Panics if the pattern is invalid.
+// Capture groups are passed to the handler, use ctx.Matches() and ctx.NamedMatch() to read them.
+func Regex(pattern string) Filter {
+	re := regexp.MustCompile(pattern)
+	return func(ctx *Context) bool {
+		text, ok := msgText(ctx)
+		if !ok {
+			return false
+		}
+		matches := re.FindStringSubmatch(text)
+		if matches == nil {
+			return false
+		}
+		ctx.matches = matches
+		ctx.matchNames = re.SubexpNames()
+		return true
+	}
+}
+
+func Prefix(prefix string) Filter {
+	return func(ctx *Context) bool {
+		text, ok := msgText(ctx)
+		return ok && strings.HasPrefix(text, prefix)
+	}
+}
+
+func Contains(substr string) Filter {
+	return func(ctx *Context) bool {
+		text, ok := msgText(ctx)
+		return ok && strings.Contains(text, substr)
+	}
+}
+
+// FromUser matches updates sent by any of the users.
+func FromUser(userIDs ...int64) Filter {
+	return func(ctx *Context) bool {
+		from := ctx.Update.SentFrom()
+		return from != nil && slices.Contains(userIDs, from.ID)
+	}
+}
+
+// InChat matches updates from any of the chats.
+// Useful for chats with ChatID 0, which handle updates from everywhere.
+func InChat(chatIDs ...int64) Filter {
+	return func(ctx *Context) bool {
+		fromChat := ctx.Update.FromChat()
+		return fromChat != nil && slices.Contains(chatIDs, fromChat.ID)
+	}
+}
+
+func HasPhoto() Filter {
+	return func(ctx *Context) bool {
+		msg := ctx.Msg()
+		return msg != nil && len(msg.Photo) > 0
+	}
+}
+
+func HasDocument() Filter {
+	return func(ctx *Context) bool {
+		msg := ctx.Msg()
+		return msg != nil && msg.Document != nil
+	}
+}
+
+// IsReply matches messages replying to another message.
+// Since the message a topic starts with is considered as the topic, replying to it is not considered as a reply.
+func IsReply() Filter {
+	return func(ctx *Context) bool {
+		msg := ctx.Msg()
+		if msg == nil || msg.ReplyToMessage == nil {
+			return false
+		}
+		if ctx.Chat == nil {
+			return true
+		}
+		topic := ctx.Chat.Target().ChatTopic
+		return topic <= 0 || msg.ReplyToMessage.MessageID != topic
+	}
+}
+
+func IsForwarded() Filter {
+	return func(ctx *Context) bool {
+		msg := ctx.Msg()
+		return msg != nil && msg.ForwardDate != 0
+	}
+}
+
+// HasEntity matches messages with any entity (or caption entity) of the types, e.g. "url", "mention", "hashtag".
+func HasEntity(entityTypes ...string) Filter {
+	return func(ctx *Context) bool {
+		msg := ctx.Msg()
+		if msg == nil {
+			return false
+		}
+		for _, entity := range append(msg.Entities, msg.CaptionEntities...) {
+			if slices.Contains(entityTypes, entity.Type) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Language matches messages from users whose language is any of the IETF language codes.
+// "en" matches "en-US" too.
+func Language(languageCodes ...string) Filter {
+	return func(ctx *Context) bool {
+		msg := ctx.Msg()
+		if msg == nil || msg.From == nil || msg.From.LanguageCode == "" {
+			return false
+		}
+		base, _, _ := strings.Cut(msg.From.LanguageCode, "-")
+		for _, code := range languageCodes {
+			if strings.EqualFold(code, msg.From.LanguageCode) || strings.EqualFold(code, base) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// The text of the message of the update, or its caption. Returns false if there is no message.
+func msgText(ctx *Context) (string, bool) {
+	msg := ctx.Msg()
+	if msg == nil {
+		return "", false
+	}
+	if msg.Text != "" {
+		return msg.Text, true
+	}
+	return msg.Caption, true
+}
+
+// Matches returns the regex match and its capture groups, set by the Regex filter.
+// matches[0] is the whole match, matches[i] is the i-th capture group. nil if no Regex filter matched.
+func (ctx *Context) Matches() []string { return ctx.matches }
+
+// NamedMatch returns the capture group with the name, e.g. "amount" for `(?P<amount>\d+)`. "" if not found.
+func (ctx *Context) NamedMatch(name string) string {
+	for i, n := range ctx.matchNames {
+		if n == name && n != "" && i < len(ctx.matches) {
+			return ctx.matches[i]
+		}
+	}
+	return ""
+}
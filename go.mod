@@ -2,4 +2,8 @@ module github.com/0xVanfer/tgx
 
 go 1.24.1
 
-require github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+	gopkg.in/yaml.v3 v3.0.1
+)
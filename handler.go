@@ -0,0 +1,184 @@
+package tgx
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// A registered msg handler, or a handler of other update kinds.
+type registeredHandler struct {
+	identifier string
+	priority   int
+	seq        uint64 // The registration order, to keep handlers of the same priority in order.
+	filter     Filter // nil to handle all updates.
+	handle     Handler
+}
+
+// Run the handler if the update matches its filter.
+func (chat *Chat) runHandler(ctx context.Context, update *Update, h *registeredHandler) error {
+	handlerCtx := chat.newContext(ctx, update)
+	if match, err := chat.matchFilter(handlerCtx, h); !match {
+		return err
+	}
+	return chat.runWithTimeout(handlerCtx, chat.wrapHandler(h.handle))
+}
+
+// Whether the update matches the filter of the handler.
+// A panicking filter does not match, and its panic is returned as a *PanicError.
+func (chat *Chat) matchFilter(ctx *Context, h *registeredHandler) (match bool, err error) {
+	if h.filter == nil {
+		return true, nil
+	}
+	defer chat.recoverHandler("filter", h.identifier, &err)
+	return h.filter(ctx), nil
+}
+
+// HandlerOption configures a handler when registering it.
+type HandlerOption func(h *registeredHandler)
+
+// WithPriority sets the priority of the handler, default 0.
+// Handlers with higher priority run first. Handlers with the same priority run in the order they are registered.
+func WithPriority(priority int) HandlerOption {
+	return func(h *registeredHandler) { h.priority = priority }
+}
+
+// Add the handler to the list, replacing the one with the same identifier (which keeps its registration order).
+// The list is kept sorted by priority.
+func (chat *Chat) addHandler(list []*registeredHandler, h *registeredHandler) []*registeredHandler {
+	chat.handlerSeq++
+	h.seq = chat.handlerSeq
+
+	replaced := false
+	for i, existing := range list {
+		if existing.identifier == h.identifier {
+			h.seq = existing.seq
+			list[i] = h
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		list = append(list, h)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].priority != list[j].priority {
+			return list[i].priority > list[j].priority
+		}
+		return list[i].seq < list[j].seq
+	})
+	return list
+}
+
+func removeHandler(list []*registeredHandler, identifier string) []*registeredHandler {
+	for i, h := range list {
+		if h.identifier == identifier {
+			return append(list[:i:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+// Run the handlers in order, or all at once if SetHandleConcurrently(true).
+// Running in order stops at the handler calling ctx.StopPropagation().
+//
+// The errors are returned as map[funcIdentifier] = error.
+func (chat *Chat) runHandlers(ctx context.Context, update *Update, handlers []*registeredHandler, concurrently bool) map[string]error {
+	errorRes := make(map[string]error)
+	state := dispatchStateFrom(ctx)
+
+	if !concurrently {
+		for _, h := range handlers {
+			if state.isStopped() {
+				break
+			}
+			err := chat.runHandler(ctx, update, h)
+			if err != nil {
+				errorRes[h.identifier] = err
+			}
+		}
+		return errorRes
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, h := range handlers {
+		wg.Add(1)
+		go func(h *registeredHandler) {
+			defer wg.Done()
+			err := chat.runHandler(ctx, update, h)
+			if err != nil {
+				mu.Lock()
+				errorRes[h.identifier] = err
+				mu.Unlock()
+			}
+		}(h)
+	}
+	wg.Wait()
+	return errorRes
+}
+
+// Run the msg handlers of the chat concurrently for each message, instead of one by one in order.
+// ctx.StopPropagation() can not stop the handlers of the chat running concurrently.
+func (chat *Chat) SetHandleConcurrently(concurrently bool) {
+	chat.handlersMu.Lock()
+	defer chat.handlersMu.Unlock()
+	chat.handleConcurrently = concurrently
+}
+
+// ========== Stop Propagation ==========
+
+// Shared by the handlers of the same update, to stop the rest once a handler says so.
+type dispatchState struct {
+	stopped atomic.Bool
+	running sync.WaitGroup // The handlers run with the handler timeout, which may outlive it.
+}
+
+func (state *dispatchState) isStopped() bool { return state != nil && state.stopped.Load() }
+
+// Track a handler running in the background until done is called.
+func (state *dispatchState) track() (done func()) {
+	if state == nil {
+		return func() {}
+	}
+	state.running.Add(1)
+	return state.running.Done
+}
+
+// Wait for the handlers of the update to return, including the ones timed out.
+func (state *dispatchState) wait() {
+	if state != nil {
+		state.running.Wait()
+	}
+}
+
+type dispatchStateKey struct{}
+
+// Start dispatching an update, all handlers running with the returned context share the same state.
+func withDispatchState(ctx context.Context) context.Context {
+	if dispatchStateFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, dispatchStateKey{}, &dispatchState{})
+}
+
+func dispatchStateFrom(ctx context.Context) *dispatchState {
+	state, _ := ctx.Value(dispatchStateKey{}).(*dispatchState)
+	return state
+}
+
+// StopPropagation marks the update as handled, so the handlers after the current one do not run,
+// including the handlers of other chats matching the update.
+func (ctx *Context) StopPropagation() {
+	if state := dispatchStateFrom(ctx); state != nil {
+		state.stopped.Store(true)
+	}
+}
+
+// IsPropagationStopped returns whether a handler called StopPropagation() for the update.
+func (ctx *Context) IsPropagationStopped() bool {
+	return dispatchStateFrom(ctx).isStopped()
+}
@@ -0,0 +1,284 @@
+package tgx
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The built-in help command, used when no chat registered its own "help".
+const helpCommandName = "help"
+
+var helpCommand = &Command{
+	Name:        helpCommandName,
+	Description: "Show available commands",
+}
+
+// The command names accepted by the Telegram command menu.
+var menuCommandRegexp = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// A command menu of a scope and language, "" for the default language.
+type commandMenu struct {
+	scope        tgbotapi.BotCommandScope
+	languageCode string
+}
+
+// LocalizedDescription returns the description of the command in the given language,
+// falls back to Description if there is no translation.
+func (cmd *Command) LocalizedDescription(languageCode string) string {
+	if desc, exist := cmd.Descriptions[languageCode]; exist && desc != "" {
+		return desc
+	}
+	// "en-US" should use "en" if no "en-US" translation.
+	if base, _, found := strings.Cut(languageCode, "-"); found {
+		if desc, exist := cmd.Descriptions[base]; exist && desc != "" {
+			return desc
+		}
+	}
+	return cmd.Description
+}
+
+// The commands of the chat, sorted by name. Hidden commands are not included.
+func (chat *Chat) listedCommands() (cmds []*Command) {
+	chat.handlersMu.RLock()
+	defer chat.handlersMu.RUnlock()
+
+	for _, cmd := range chat.commands {
+		if !cmd.Hidden {
+			cmds = append(cmds, cmd)
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return
+}
+
+// Merge the listed commands of the chats, the first chat wins if a name is registered more than once.
+func mergeListedCommands(chats []*Chat) (cmds []*Command) {
+	seen := make(map[string]bool)
+	for _, chat := range chats {
+		for _, cmd := range chat.listedCommands() {
+			name := strings.ToLower(cmd.Name)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			cmds = append(cmds, cmd)
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return
+}
+
+// Build the help text of the commands in the given language.
+func helpText(cmds []*Command, languageCode string) string {
+	var b strings.Builder
+	b.WriteString("Available commands:")
+	for _, cmd := range cmds {
+		b.WriteString("\n" + cmd.Usage())
+		if desc := cmd.LocalizedDescription(languageCode); desc != "" {
+			b.WriteString(" - " + desc)
+		}
+		if len(cmd.Aliases) > 0 {
+			b.WriteString(" (aliases: /" + strings.Join(cmd.Aliases, ", /") + ")")
+		}
+	}
+	return b.String()
+}
+
+// Reply the built-in /help, listing the commands of every chat of the bot matching the msg.
+//
+// Returns the chat replying, nil if the msg is not /help, or any matching chat registered its own "help".
+// Nothing is replied if no matching chat has commands.
+func (b *botInfo) handleHelp(ctx context.Context, update *Update) (replied *Chat, err error) {
+	if b.wrapper != nil && b.wrapper.disableHelp {
+		return nil, nil
+	}
+	msg := update.Message
+	if msg == nil || !msg.IsCommand() || !strings.EqualFold(msg.Command(), helpCommandName) {
+		return nil, nil
+	}
+
+	var chats []*Chat
+	for _, chat := range b.Chats {
+		if !b.isRegistered(chat) || !chat.matchUpdate(update) {
+			continue
+		}
+		if !chat.isCommandToMe(msg) {
+			return nil, nil
+		}
+		if _, exist := chat.findCommand(helpCommandName); exist {
+			return nil, nil
+		}
+		chats = append(chats, chat)
+	}
+	cmds := mergeListedCommands(chats)
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+
+	err = chats[0].runWithTimeout(chats[0].newContext(ctx, update), chats[0].wrapHandler(func(ctx *Context) error {
+		var languageCode string
+		if msg.From != nil {
+			languageCode = msg.From.LanguageCode
+		}
+		_, err := ctx.Reply(helpText(cmds, languageCode))
+		return err
+	}))
+	return chats[0], err
+}
+
+// SyncCommands pushes the commands of every registered chat to Telegram, so the command menu of each chat
+// matches what the chat can actually do.
+//
+// Chats with ChatID 0 set the default commands of the bot.
+// Other chats set the commands of their chat ID, including the ones of the chats with ChatID 0,
+// since commands of a chat scope override the default ones in Telegram.
+// Telegram does not support topic level command menus, so all topics of a chat share the same menu.
+//
+// A menu is pushed for each language used in Command.Descriptions, besides the default one.
+// Chats left without commands get their menu deleted, and so do the menus pushed before that are gone,
+// e.g. of the languages no longer used. Commands with names Telegram does not accept are left out of the menus.
+func (tg *TgWrapper) SyncCommands() error {
+	return tg.SyncCommandsContext(context.Background())
+}
+
+// Same as SyncCommands(), stopping the retries and HTTP requests when ctx is done.
+func (tg *TgWrapper) SyncCommandsContext(ctx context.Context) error {
+	tg.syncMu.Lock()
+	defer tg.syncMu.Unlock()
+
+	if tg.syncedMenus == nil {
+		tg.syncedMenus = make(map[string]map[commandMenu]bool)
+	}
+	for botToken, b := range tg.GetAllRegisteredBots() {
+		if b.Bot == nil {
+			continue
+		}
+		if tg.syncedMenus[botToken] == nil {
+			tg.syncedMenus[botToken] = make(map[commandMenu]bool)
+		}
+		if err := b.syncCommands(ctx, tg.syncedMenus[botToken]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Push the menus of the chats, and delete the synced menus that are gone. synced is updated with the menus pushed.
+func (b *botInfo) syncCommands(ctx context.Context, synced map[commandMenu]bool) error {
+	var everywhere []*Chat
+	byChatID := make(map[int64][]*Chat)
+	for _, chat := range b.Chats {
+		if chatID := chat.Target().ChatID; chatID == 0 {
+			everywhere = append(everywhere, chat)
+		} else {
+			byChatID[chatID] = append(byChatID[chatID], chat)
+		}
+	}
+
+	// map[menu] = true if pushed, false if deleted.
+	menus := make(map[commandMenu]bool)
+	defer func() {
+		for menu, pushed := range menus {
+			if pushed {
+				synced[menu] = true
+			} else {
+				delete(synced, menu)
+			}
+		}
+	}()
+
+	if len(everywhere) > 0 {
+		err := b.setCommands(ctx, tgbotapi.NewBotCommandScopeDefault(), mergeListedCommands(everywhere), menus)
+		if err != nil {
+			return err
+		}
+	}
+	for chatID, chats := range byChatID {
+		err := b.setCommands(ctx, tgbotapi.NewBotCommandScopeChat(chatID), mergeListedCommands(append(chats, everywhere...)), menus)
+		if err != nil {
+			return err
+		}
+	}
+
+	// E.g. no chat has ChatID 0 anymore, or a language is no longer used.
+	for menu := range synced {
+		if _, done := menus[menu]; done {
+			continue
+		}
+		err := b.Chats[0].requestWithRetry(ctx, tgbotapi.NewDeleteMyCommandsWithScopeAndLanguage(menu.scope, menu.languageCode))
+		if err != nil {
+			return err
+		}
+		menus[menu] = false
+	}
+	return nil
+}
+
+// Set the commands of the scope for the default language and each translated language, recorded in menus.
+func (b *botInfo) setCommands(ctx context.Context, scope tgbotapi.BotCommandScope, cmds []*Command, menus map[commandMenu]bool) error {
+	var valid []*Command
+	for _, cmd := range cmds {
+		if !menuCommandRegexp.MatchString(strings.ToLower(cmd.Name)) {
+			b.wrapper.getLogger().Warn("tgx: command left out of the menu, invalid name", slog.String("command", cmd.Name))
+			continue
+		}
+		valid = append(valid, cmd)
+	}
+	cmds = valid
+
+	if len(cmds) == 0 {
+		err := b.Chats[0].requestWithRetry(ctx, tgbotapi.NewDeleteMyCommandsWithScope(scope))
+		if err != nil {
+			return err
+		}
+		menus[commandMenu{scope: scope}] = false
+		return nil
+	}
+	if b.wrapper == nil || !b.wrapper.disableHelp {
+		hasHelp := false
+		for _, cmd := range cmds {
+			hasHelp = hasHelp || strings.EqualFold(cmd.Name, helpCommandName)
+		}
+		if !hasHelp {
+			cmds = append(cmds, helpCommand)
+		}
+	}
+
+	languageCodes := []string{""}
+	seen := make(map[string]bool)
+	for _, cmd := range cmds {
+		for languageCode := range cmd.Descriptions {
+			if !seen[languageCode] {
+				seen[languageCode] = true
+				languageCodes = append(languageCodes, languageCode)
+			}
+		}
+	}
+	sort.Strings(languageCodes[1:])
+
+	for _, languageCode := range languageCodes {
+		botCommands := make([]tgbotapi.BotCommand, 0, len(cmds))
+		for _, cmd := range cmds {
+			desc := cmd.LocalizedDescription(languageCode)
+			if desc == "" {
+				// Telegram requires a description.
+				desc = cmd.Usage()
+			}
+			botCommands = append(botCommands, tgbotapi.BotCommand{
+				Command:     strings.ToLower(cmd.Name),
+				Description: desc,
+			})
+		}
+		err := b.Chats[0].requestWithRetry(ctx, tgbotapi.NewSetMyCommandsWithScopeAndLanguage(scope, languageCode, botCommands...))
+		if err != nil {
+			return err
+		}
+		menus[commandMenu{scope: scope, languageCode: languageCode}] = true
+	}
+	return nil
+}
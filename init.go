@@ -1,6 +1,7 @@
 package tgx
 
 import (
+	"net/http"
 	"sync"
 )
 
@@ -17,12 +18,26 @@ import (
 // 3. The link will look like this: https://t.me/c/123456789/2/21 (If topic is not allowed, the link will look like this: https://t.me/c/123456789/21).
 //
 // 4. The ChatID is -100123456789 (adding -100 at the beginning), and the ChatTopic is 2. 21 is the message ID, which is not used in this package.
+//
+// Or set Link to the copied link instead of ChatID and ChatTopic, see ParseMsgLink(). A link without topic registers the entire chat.
 type SingleChatConf struct {
 	BotToken    string `json:"bot_token" mapstructure:"bot_token"`
 	ChatID      int64  `json:"chat_id" mapstructure:"chat_id"`
 	ChatTopic   int    `json:"chat_topic" mapstructure:"chat_topic"`
 	Identifier  string `json:"identifier" mapstructure:"identifier"`
 	Description string `json:"description,omitempty" mapstructure:"description"`
+
+	// Optional. A message link like https://t.me/c/123456789/2/21, setting ChatID and ChatTopic. Public links are not supported.
+	Link string `json:"link,omitempty" mapstructure:"link"`
+
+	// Optional. The Bot API server, overriding the ones of the wrapper. See TgWrapper.SetAPIEndpoint().
+	// The bot of a token is created by its first chat, so APIEndpoint, HTTPClient and Bot of the later chats are ignored.
+	APIEndpoint  string       `json:"api_endpoint,omitempty" mapstructure:"api_endpoint"`
+	FileEndpoint string       `json:"file_endpoint,omitempty" mapstructure:"file_endpoint"`
+	HTTPClient   *http.Client `json:"-" mapstructure:"-"`
+
+	// Optional. The BotAPI of the chat, e.g. a fake for tests. BotToken defaults to Bot.Token() if empty.
+	Bot BotAPI `json:"-" mapstructure:"-"`
 }
 
 // Each conf should be valid, otherwise will return an error.
@@ -0,0 +1,63 @@
+package tgxutils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket allows count events per period, with bursts of up to count events.
+type TokenBucket struct {
+	mu       sync.Mutex
+	interval time.Duration // The time to refill one token.
+	burst    time.Duration // The time to refill the whole bucket, minus one token.
+	next     time.Time     // When the bucket is empty, the time a token is available.
+}
+
+// A bucket with count tokens refilled every period. Returns nil (no limit) if count or period <= 0.
+func NewTokenBucket(count int, period time.Duration) *TokenBucket {
+	if count <= 0 || period <= 0 {
+		return nil
+	}
+	interval := period / time.Duration(count)
+	return &TokenBucket{
+		interval: interval,
+		burst:    interval * time.Duration(count-1),
+	}
+}
+
+// Take a token, returning how long to wait before using it. A nil bucket never waits.
+func (b *TokenBucket) Reserve() time.Duration {
+	return b.ReserveAt(time.Now())
+}
+
+// Same as Reserve(), taking the token at the given time.
+func (b *TokenBucket) ReserveAt(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	next := b.next
+	if next.Before(now) {
+		next = now
+	}
+	b.next = next.Add(b.interval)
+	return max(next.Sub(now)-b.burst, 0)
+}
+
+// Whether the bucket is full at the given time, so it is the same as a new bucket. A nil bucket is always full.
+func (b *TokenBucket) FullAt(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.next.After(now)
+}
+
+// Take a token and wait until it can be used, or ctx is done.
+func (b *TokenBucket) Wait(ctx context.Context) (time.Duration, error) {
+	wait := b.Reserve()
+	return wait, Sleep(ctx, wait)
+}
@@ -1,15 +1,84 @@
 package tgxutils
 
-import "time"
+import (
+	"context"
+	"math/rand/v2"
+	"time"
 
-func Retry(callback func() error, maxRetries int, interval time.Duration) error {
-	var err error
-	for i := 1; i <= maxRetries; i++ {
-		if err = callback(); err != nil {
-			time.Sleep(interval)
-			continue
+	"github.com/0xVanfer/tgx/tgxerrors"
+)
+
+// How to retry a failed request.
+type RetryPolicy struct {
+	MaxAttempts  int           // Including the first attempt.
+	Interval     time.Duration // The backoff before the first retry, doubled after each retry.
+	MaxInterval  time.Duration // The max backoff, 0 for no limit.
+	MaxFloodWait time.Duration // Flood waits longer than this fail immediately, 0 for no limit.
+
+	// Called before waiting for the next attempt, may be nil.
+	OnRetry func(attempt int, err *tgxerrors.RequestError, wait time.Duration)
+}
+
+// Retry calls callback until it succeeds, fails with a permanent error, fails MaxAttempts times, or ctx is done.
+//
+// Retryable errors wait for an exponential backoff with jitter,
+// flood waits wait for the RetryAfter given by Telegram.
+// The returned error is a *tgxerrors.RequestError.
+func Retry(ctx context.Context, callback func() error, policy RetryPolicy) error {
+	var err *tgxerrors.RequestError
+	for attempt := 1; ; attempt++ {
+		err = tgxerrors.NewRequestError(callback())
+		if err == nil {
+			return nil
+		}
+		if attempt >= policy.MaxAttempts || err.Class == tgxerrors.ClassPermanent {
+			return err
+		}
+
+		wait := Backoff(attempt, policy.Interval, policy.MaxInterval)
+		if err.Class == tgxerrors.ClassFloodWait {
+			if policy.MaxFloodWait > 0 && err.RetryAfter > policy.MaxFloodWait {
+				return err
+			}
+			wait = max(err.RetryAfter, wait)
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, err, wait)
+		}
+		if e := Sleep(ctx, wait); e != nil {
+			return tgxerrors.NewRequestError(e)
 		}
+	}
+}
+
+// Backoff returns the wait before the next attempt after the attempt-th one:
+// interval * 2^(attempt-1) capped at maxInterval, with up to ±20% jitter.
+func Backoff(attempt int, interval time.Duration, maxInterval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	wait := interval
+	for i := 1; i < attempt && (maxInterval <= 0 || wait < maxInterval); i++ {
+		wait *= 2
+	}
+	if maxInterval > 0 {
+		wait = min(wait, maxInterval)
+	}
+	jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(wait))
+	return wait + jitter
+}
+
+// Sleep for d, returning ctx.Err() if ctx is done earlier.
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
 		return nil
 	}
-	return err
 }
@@ -0,0 +1,208 @@
+package tgx
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/0xVanfer/tgx/internal/tgxutils"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The backoff between the attempts of connecting a lazily registered bot.
+const (
+	lazyConnectInterval    = time.Second
+	lazyConnectMaxInterval = time.Minute
+)
+
+var botTokenRegexp = regexp.MustCompile(`^\d+:[\w-]+$`)
+
+// InitLazy is Init() with lazy registration, see SetLazyRegistration().
+// It only fails on invalid confs, not when Telegram is unreachable.
+func InitLazy(conf ...SingleChatConf) (tg *TgWrapper, err error) {
+	tg = &TgWrapper{chatsByIdentifier: sync.Map{}, lazy: true}
+
+	for _, c := range conf {
+		_, err = tg.RegisterChat(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tg, nil
+}
+
+// Set whether RegisterChat() connects the bots in the background, default false.
+//
+// With lazy registration, the conf is only validated offline, and the bot keeps calling getMe
+// with backoff until it succeeds or the token is rejected. See GetBotHealth() for the state.
+//
+// Until the bot is connected, sends with a context wait for it until the context is done,
+// other sends fail with ErrBotNotReady, which is retried like temporary network errors.
+// Bots registered by SingleChatConf.Bot are already connected.
+func (tg *TgWrapper) SetLazyRegistration(lazy bool) { tg.lazy = lazy }
+
+// Check the conf without calling Telegram.
+func validateLazyConf(conf SingleChatConf) error {
+	if conf.Bot == nil && !botTokenRegexp.MatchString(conf.BotToken) {
+		return tgxerrors.ErrInvalidBotToken
+	}
+	return nil
+}
+
+// The connection of a lazily registered bot, shared by the chats of the token.
+type lazyConn struct {
+	token   string
+	done    chan struct{} // Closed when connected or failed.
+	stopped chan struct{} // Closed when the bot is unregistered.
+	stop    func()
+
+	// Set before done is closed.
+	bot BotAPI
+	err error // The error rejecting the token.
+}
+
+// The BotAPI of a lazily registered bot, forwarding the requests once connected.
+type lazyBot struct {
+	*lazyConn
+	ctx context.Context // Waits for the connection until ctx is done, nil to fail at once.
+}
+
+func newLazyBot(token string) *lazyBot {
+	conn := &lazyConn{token: token, done: make(chan struct{}), stopped: make(chan struct{})}
+	conn.stop = sync.OnceFunc(func() { close(conn.stopped) })
+	return &lazyBot{lazyConn: conn}
+}
+
+// The connected bot, ErrBotNotReady if still connecting.
+func (c *lazyConn) current() (BotAPI, error) {
+	select {
+	case <-c.done:
+		return c.bot, c.err
+	default:
+		return nil, tgxerrors.ErrBotNotReady
+	}
+}
+
+// Wait for the connected bot until ctx is done.
+func (c *lazyConn) wait(ctx context.Context) (BotAPI, error) {
+	select {
+	case <-c.done:
+		return c.bot, c.err
+	case <-c.stopped:
+		return nil, tgxerrors.ErrBotNotFound
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *lazyConn) connected() bool {
+	bot, err := c.current()
+	return err == nil && bot != nil
+}
+
+// Whether the token is rejected, see BotFailed.
+func (c *lazyConn) failed() bool {
+	_, err := c.current()
+	return err != nil && !errors.Is(err, tgxerrors.ErrBotNotReady)
+}
+
+func (b *lazyBot) get() (BotAPI, error) {
+	if b.ctx == nil {
+		return b.current()
+	}
+	bot, err := b.wait(b.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if contextBot, ok := bot.(ContextBotAPI); ok {
+		return contextBot.WithContext(b.ctx), nil
+	}
+	return bot, nil
+}
+
+func (b *lazyBot) Token() string { return b.token }
+
+// The bot itself, empty until connected.
+func (b *lazyBot) Self() tgbotapi.User {
+	if bot, err := b.current(); err == nil {
+		return bot.Self()
+	}
+	return tgbotapi.User{}
+}
+
+func (b *lazyBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	bot, err := b.get()
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+	return bot.Send(c)
+}
+
+func (b *lazyBot) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	bot, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return bot.Request(c)
+}
+
+func (b *lazyBot) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	bot, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return bot.MakeRequest(endpoint, params)
+}
+
+func (b *lazyBot) WithContext(ctx context.Context) BotAPI {
+	return &lazyBot{lazyConn: b.lazyConn, ctx: ctx}
+}
+
+// Whether Telegram rejects the token: 401 for unknown tokens, 404 for malformed ones.
+// Other errors, even permanent ones like the error pages of proxies, may be gone on the next attempt.
+func isTokenRejected(err error) bool {
+	var apiErr *tgbotapi.Error
+	return errors.As(err, &apiErr) && (apiErr.Code == 401 || apiErr.Code == 404)
+}
+
+// Keep creating the bot of the conf until it succeeds, the token is rejected, or the bot is unregistered.
+func (tg *TgWrapper) connect(conf SingleChatConf, entry *registeredBot, lazy *lazyBot) {
+	for attempt := 1; ; attempt++ {
+		bot, err := tg.newBotAPI(conf)
+		if err == nil {
+			lazy.bot = bot
+			// Set the status first, so it is up to date once the waiting sends go on.
+			entry.setStatus(BotConnected, nil)
+			close(lazy.done)
+			tg.getLogger().Info("tgx: bot connected", slog.String("bot", bot.Self().UserName), slog.Int("attempts", attempt))
+			return
+		}
+
+		requestErr := tgxerrors.NewRequestError(err)
+		if isTokenRejected(err) {
+			// Retrying does not help if the token is rejected.
+			lazy.err = requestErr
+			entry.setStatus(BotFailed, requestErr)
+			close(lazy.done)
+			tg.getLogger().Error("tgx: connect bot failed", slog.String("error", redactToken(err.Error(), conf.BotToken)))
+			return
+		}
+
+		wait := max(tgxutils.Backoff(attempt, lazyConnectInterval, lazyConnectMaxInterval), requestErr.RetryAfter)
+		entry.setStatus(BotConnecting, requestErr)
+		tg.getLogger().Warn("tgx: connect bot failed, retrying",
+			slog.Int("attempt", attempt),
+			slog.Duration("wait", wait),
+			slog.String("error", redactToken(err.Error(), conf.BotToken)),
+		)
+		select {
+		case <-lazy.stopped:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
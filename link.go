@@ -0,0 +1,176 @@
+package tgx
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/0xVanfer/tgx/tgxerrors"
+)
+
+// MsgLink is a Telegram message link, as copied by "Copy Message Link":
+//
+// - https://t.me/c/123456789/21 for a message of a private group or channel,
+// - https://t.me/c/123456789/2/21 for a message in topic 2,
+// - https://t.me/username/21 and https://t.me/username/2/21 for public chats.
+type MsgLink struct {
+	// ChatID is 0 for public links, whose chats are known by Username only.
+	// ChatTopic is -1 if the link has no topic.
+	ChatAndTopic
+	Username  string
+	MessageID int
+}
+
+// Private chats are linked by the chat ID without the -100 prefix.
+const privateLinkPrefix = "-100"
+
+var usernameRegexp = regexp.MustCompile(`^[A-Za-z]\w{3,31}$`)
+
+// ParseMsgLink parses a message link into the chat, topic and message ID.
+// The scheme is optional, and t.me, telegram.me and telegram.dog are accepted.
+// The topic can also be given by the "thread" or "topic" query, e.g. https://t.me/c/123456789/21?thread=2.
+func ParseMsgLink(link string) (*MsgLink, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w %q: %s", tgxerrors.ErrInvalidMsgLink, link, reason)
+	}
+
+	raw := strings.TrimSpace(link)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, invalid(err.Error())
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, invalid("not an http(s) link")
+	}
+	switch strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.") {
+	case "t.me", "telegram.me", "telegram.dog":
+	default:
+		return nil, invalid("not a Telegram link")
+	}
+
+	parsed := &MsgLink{ChatAndTopic: ChatAndTopic{ChatTopic: -1}}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if segments[0] == "c" {
+		if len(segments) < 2 {
+			return nil, invalid("no chat ID")
+		}
+		id, err := strconv.ParseInt(segments[1], 10, 64)
+		if err != nil || id <= 0 {
+			return nil, invalid("chat ID should be a positive number")
+		}
+		if parsed.ChatID, err = strconv.ParseInt(privateLinkPrefix+segments[1], 10, 64); err != nil {
+			return nil, invalid("chat ID is too large")
+		}
+	} else {
+		if !usernameRegexp.MatchString(segments[0]) {
+			return nil, invalid("invalid username")
+		}
+		parsed.Username = segments[0]
+	}
+	// The chat is followed by [topic/]message.
+	ids := segments[1:]
+	if parsed.Username == "" {
+		ids = segments[2:]
+	}
+
+	numbers := make([]int, len(ids))
+	for i, id := range ids {
+		if numbers[i], err = strconv.Atoi(id); err != nil || numbers[i] <= 0 {
+			return nil, invalid("message and topic IDs should be positive numbers")
+		}
+	}
+	switch len(numbers) {
+	case 1:
+		parsed.MessageID = numbers[0]
+	case 2:
+		parsed.ChatTopic, parsed.MessageID = numbers[0], numbers[1]
+	default:
+		return nil, invalid("should be the chat, an optional topic and the message ID")
+	}
+
+	if thread := queryValue(u.Query(), "thread", "topic"); thread != "" && parsed.ChatTopic < 0 {
+		if parsed.ChatTopic, err = strconv.Atoi(thread); err != nil || parsed.ChatTopic <= 0 {
+			return nil, invalid("topic should be a positive number")
+		}
+	}
+	return parsed, nil
+}
+
+// The first non-empty query value of the keys.
+func queryValue(query url.Values, keys ...string) string {
+	for _, key := range keys {
+		if value := query.Get(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// The link like https://t.me/c/123456789/2/21, "" if it can not be linked.
+func (link MsgLink) String() string {
+	var chat string
+	switch {
+	case link.Username != "":
+		chat = link.Username
+	case strings.HasPrefix(strconv.FormatInt(link.ChatID, 10), privateLinkPrefix):
+		chat = "c/" + strings.TrimPrefix(strconv.FormatInt(link.ChatID, 10), privateLinkPrefix)
+	default:
+		return ""
+	}
+	if link.ChatTopic > 0 {
+		return fmt.Sprintf("https://t.me/%s/%d/%d", chat, link.ChatTopic, link.MessageID)
+	}
+	return fmt.Sprintf("https://t.me/%s/%d", chat, link.MessageID)
+}
+
+// Link returns the link of the message, public if the chat has a username.
+// The link has the topic if the msg is managed by a chat with ChatTopic > 0, replies in other chats are not topics.
+// Messages of private chats and basic groups have no links.
+func (msg *ChatMsg) Link() (string, error) {
+	if msg == nil || msg.Msg == nil || msg.Msg.Chat == nil {
+		return "", tgxerrors.ErrMsgNotFound
+	}
+	link := MsgLink{
+		ChatAndTopic: ChatAndTopic{ChatID: msg.Msg.Chat.ID, ChatTopic: -1},
+		Username:     msg.Msg.Chat.UserName,
+		MessageID:    msg.Msg.MessageID,
+	}
+	// Messages sent to a topic reply to the message the topic starts with, see Update.Topic().
+	// tgbotapi does not decode message_thread_id, so a reply is taken as the topic only if the msg is managed by a topic chat.
+	if reply := msg.Msg.ReplyToMessage; reply != nil && msg.Chat != nil {
+		if target := msg.Chat.Target(); target.ChatID == link.ChatID && target.ChatTopic > 0 {
+			link.ChatTopic = reply.MessageID
+		}
+	}
+	if msg.Msg.Chat.IsPrivate() {
+		return "", tgxerrors.ErrNoMsgLink
+	}
+	if s := link.String(); s != "" {
+		return s, nil
+	}
+	return "", tgxerrors.ErrNoMsgLink
+}
+
+// The conf with ChatID and ChatTopic read from Link, if set. Link is cleared, so it can be resolved again.
+func (conf SingleChatConf) resolveLink() (SingleChatConf, error) {
+	if conf.Link == "" {
+		return conf, nil
+	}
+	if conf.ChatID != 0 || conf.ChatTopic != 0 {
+		return conf, fmt.Errorf("%w: set either link, or chat_id and chat_topic", tgxerrors.ErrInvalidMsgLink)
+	}
+	link, err := ParseMsgLink(conf.Link)
+	if err != nil {
+		return conf, err
+	}
+	if link.Username != "" {
+		return conf, tgxerrors.ErrPublicMsgLink
+	}
+	conf.ChatID, conf.ChatTopic, conf.Link = link.ChatID, link.ChatTopic, ""
+	return conf, nil
+}
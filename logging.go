@@ -0,0 +1,142 @@
+package tgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/0xVanfer/tgx/internal/tgxutils"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Set the logger of the wrapper, used by all chats without their own logger. Default slog.Default().
+//
+// Sends, edits and deletes are logged at debug level, retries at warn level,
+// and failed requests, handler errors, panics and polling failures at error level.
+// Bot tokens never appear in the logs.
+func (tg *TgWrapper) SetLogger(logger *slog.Logger) { tg.logger = logger }
+
+// Set the logger of the chat, overriding the one of the wrapper.
+func (chat *Chat) SetLogger(logger *slog.Logger) { chat.logger = logger }
+
+func (tg *TgWrapper) getLogger() *slog.Logger {
+	if tg != nil && tg.logger != nil {
+		return tg.logger
+	}
+	return slog.Default()
+}
+
+func (chat *Chat) getLogger() *slog.Logger {
+	if chat.logger != nil {
+		return chat.logger
+	}
+	return chat.wrapper.getLogger()
+}
+
+// The attributes identifying the chat.
+func (chat *Chat) logAttrs() []any {
+	target := chat.Target()
+	return []any{
+		slog.String("chat", chat.Identifier),
+		slog.Int64("chat_id", target.ChatID),
+		slog.Int("topic", target.ChatTopic),
+	}
+}
+
+// The error message without the bot token, which may be in the request URL of http errors.
+func (chat *Chat) redact(err error) string {
+	if err == nil {
+		return ""
+	}
+	if chat.Bot == nil {
+		return err.Error()
+	}
+	return redactToken(err.Error(), chat.Bot.Token())
+}
+
+func redactToken(text string, token string) string {
+	if token == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, token, "<bot_token>")
+}
+
+// The panic value without the bot token, e.g. of a handler panicking with an error from a request.
+func (chat *Chat) redactPanic(r any) string {
+	if chat.Bot == nil {
+		return fmt.Sprint(r)
+	}
+	return redactToken(fmt.Sprint(r), chat.Bot.Token())
+}
+
+// The error without the bot token. The class of the error is kept, but not the original error,
+// which still has the token, e.g. in the request URL of a *url.Error.
+func redactError(err error, token string) error {
+	if err == nil || token == "" || !strings.Contains(err.Error(), token) {
+		return err
+	}
+	redacted := *tgxerrors.NewRequestError(err)
+	redacted.Err = errors.New(redactToken(err.Error(), token))
+	return &redacted
+}
+
+// The name and the target of a request, for logging.
+func requestAttrs(c tgbotapi.Chattable) (event string, attrs []any) {
+	switch c := c.(type) {
+	case tgbotapi.MessageConfig:
+		return "send", []any{slog.Int64("target_chat_id", c.ChatID), slog.Int("target_topic", c.ReplyToMessageID)}
+	case tgbotapi.PhotoConfig:
+		return "send", []any{slog.Int64("target_chat_id", c.ChatID), slog.Int("target_topic", c.ReplyToMessageID)}
+	case tgbotapi.EditMessageTextConfig:
+		return "edit", []any{slog.Int64("target_chat_id", c.ChatID), slog.Int("message_id", c.MessageID)}
+	case tgbotapi.DeleteMessageConfig:
+		return "delete", []any{slog.Int64("target_chat_id", c.ChatID), slog.Int("message_id", c.MessageID)}
+	default:
+		return "request", []any{slog.String("request", fmt.Sprintf("%T", c))}
+	}
+}
+
+// Run the request with retry, logging the failed attempts and the result.
+// msgID returns the ID of the message sent, 0 if not known.
+func (chat *Chat) retryRequest(ctx context.Context, event string, attrs []any, request func() error, msgID func() int) error {
+	logger := chat.getLogger().With(chat.logAttrs()...).With(attrs...)
+	start := time.Now()
+	attempt := 1
+	policy := chat.retryPolicy()
+	policy.OnRetry = func(n int, e *tgxerrors.RequestError, wait time.Duration) {
+		attempt = n + 1
+		logger.Warn("tgx: "+event+" failed, retrying",
+			slog.Int("attempt", n), slog.String("class", e.Class.String()), slog.Duration("wait", wait), slog.String("error", chat.redact(e)))
+	}
+	err := tgxutils.Retry(ctx, request, policy)
+
+	duration := slog.Duration("duration", time.Since(start))
+	if err != nil {
+		logger.Error("tgx: "+event+" failed", slog.Int("attempts", attempt), duration,
+			slog.String("class", tgxerrors.NewRequestError(err).Class.String()), slog.String("error", chat.redact(err)))
+		return err
+	}
+	if logger.Enabled(ctx, slog.LevelDebug) {
+		if id := msgID(); id != 0 {
+			logger = logger.With(slog.Int("message_id", id))
+		}
+		logger.Debug("tgx: "+event, slog.Int("attempts", attempt), duration)
+	}
+	return nil
+}
+
+// Log the error returned by a handler.
+func (chat *Chat) logHandlerError(update *Update, handler string, err error) {
+	attrs := append(chat.logAttrs(), slog.String("handler", handler), slog.String("error", chat.redact(err)))
+	if update != nil {
+		attrs = append(attrs, slog.Int("update_id", update.UpdateID), slog.String("update_kind", string(update.Kind())))
+		if msg := update.Msg(); msg != nil {
+			attrs = append(attrs, slog.Int("message_id", msg.MessageID))
+		}
+	}
+	chat.getLogger().Error("tgx: handler failed", attrs...)
+}
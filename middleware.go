@@ -0,0 +1,70 @@
+package tgx
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Handler handles an update. It is the type of all handlers, so middlewares can wrap
+// commands, msg handlers and the handlers of other update kinds alike.
+type Handler func(ctx *Context) (err error)
+
+// Middleware wraps a handler, e.g. for logging, auth, rate limiting, timing or error replies.
+// Return without calling next to stop the handler from running.
+//
+// Middlewares can be used at the wrapper, bot or chat level, and run in that order (the wrapper ones are the outermost).
+// Middlewares of the same level run in the order they are added.
+type Middleware func(next Handler) Handler
+
+// Use adds middlewares for the handlers of all chats.
+func (tg *TgWrapper) Use(middlewares ...Middleware) {
+	tg.middlewaresMu.Lock()
+	defer tg.middlewaresMu.Unlock()
+	tg.middlewares = append(tg.middlewares, middlewares...)
+}
+
+// UseForBot adds middlewares for the handlers of all chats using the bot.
+func (tg *TgWrapper) UseForBot(botToken string, middlewares ...Middleware) {
+	tg.middlewaresMu.Lock()
+	defer tg.middlewaresMu.Unlock()
+	if tg.botMiddlewares == nil {
+		tg.botMiddlewares = make(map[string][]Middleware)
+	}
+	tg.botMiddlewares[botToken] = append(tg.botMiddlewares[botToken], middlewares...)
+}
+
+// Use adds middlewares for the handlers of the chat.
+func (chat *Chat) Use(middlewares ...Middleware) {
+	chat.middlewaresMu.Lock()
+	defer chat.middlewaresMu.Unlock()
+	chat.middlewares = append(chat.middlewares, middlewares...)
+}
+
+// The middlewares of the wrapper, the bot and the chat, from the outermost to the innermost.
+func (chat *Chat) middlewareChain() (chain []Middleware) {
+	if tg := chat.wrapper; tg != nil {
+		tg.middlewaresMu.RLock()
+		chain = append(chain, tg.middlewares...)
+		if chat.Bot != nil {
+			chain = append(chain, tg.botMiddlewares[chat.Bot.Token()]...)
+		}
+		tg.middlewaresMu.RUnlock()
+	}
+	chat.middlewaresMu.RLock()
+	chain = append(chain, chat.middlewares...)
+	chat.middlewaresMu.RUnlock()
+	return
+}
+
+// Wrap the handler with the middlewares of the chat.
+func (chat *Chat) wrapHandler(handler Handler) Handler {
+	chain := chat.middlewareChain()
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+	return handler
+}
+
+// Wrap the msg into an update, for the functions receiving msg only.
+func msgToUpdate(msg *tgbotapi.Message) *Update {
+	return &Update{Update: tgbotapi.Update{Message: msg}}
+}
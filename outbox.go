@@ -0,0 +1,466 @@
+package tgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/0xVanfer/tgx/tgxerrors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// What to do when enqueuing to a full outbox.
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // Wait until there is room.
+	OverflowDropOldest                       // Drop the oldest queued message, which fails with ErrOutboxDropped.
+	OverflowDropNewest                       // Drop the message being enqueued, which fails with ErrOutboxFull.
+)
+
+type OutboxConfig struct {
+	Capacity int            // The max number of queued messages, default 1000.
+	Overflow OverflowPolicy // Default OverflowBlock.
+	Workers  int            // The number of messages sent at the same time, default 1. Messages to the same chat are always sent in order.
+
+	// Called after each message is sent or failed, may be nil.
+	OnResult func(msg *OutboxMsg, msgsSent []*tgbotapi.Message, err error)
+
+	// Makes the outbox durable if not nil, see NewFileOutboxStore().
+	//
+	// Messages are saved before queued and removed after sent, so they are delivered at least once:
+	// messages left when the process stops are sent again by the next StartOutbox().
+	// Messages failed with temporary errors (e.g. Telegram is down) are sent again after RedeliverInterval,
+	// instead of failing.
+	Store             OutboxStore
+	RedeliverInterval time.Duration // Default 10 seconds.
+
+	// How long the keys of sent messages are remembered to drop duplicates, default 24 hours.
+	DedupWindow time.Duration
+}
+
+// A message waiting in the outbox.
+type OutboxMsg struct {
+	ID         string           `json:"id"`             // Set when queued.
+	Key        string           `json:"key,omitempty"`  // Optional. Messages with the same key are sent only once, see Enqueue().
+	Chat       string           `json:"chat"`           // The identifier of the chat sending the message.
+	Target     *ChatAndTopic    `json:"target"`         // Overrides the chat ID and topic if not nil.
+	Text       string           `json:"text,omitempty"` // Sent by SendTextMsg() if Components is empty.
+	Components [][]MsgComponent `json:"components,omitempty"`
+	Time       time.Time        `json:"time"` // When queued.
+}
+
+// OutboxStore saves the queued messages of a durable outbox.
+type OutboxStore interface {
+	// Save the message before it is queued.
+	Add(msg *OutboxMsg) error
+	// Remove the message after it is sent or failed permanently.
+	// The key of the message, if any, should be kept for dedup.
+	Done(msg *OutboxMsg) error
+	// Remove the message dropped by OverflowDropOldest. It is not sent, so its key should not be kept.
+	Drop(msg *OutboxMsg) error
+	// Load the messages not done in the order they were added,
+	// and the keys of the messages done with the time they were done.
+	Load() (pending []*OutboxMsg, doneKeys map[string]time.Time, err error)
+}
+
+// SendHandle is returned when enqueuing a message, to wait for the result.
+type SendHandle struct {
+	Msg *OutboxMsg
+
+	done     chan struct{}
+	msgsSent []*tgbotapi.Message
+	err      error
+}
+
+// Done is closed once the message is sent or failed.
+func (h *SendHandle) Done() <-chan struct{} { return h.done }
+
+// Wait for the message to be sent, returning the same as SendTextMsg().
+func (h *SendHandle) Wait() ([]*tgbotapi.Message, error) {
+	<-h.done
+	return h.msgsSent, h.err
+}
+
+// Outbox sends messages in the background, so callers do not wait for retries.
+type Outbox struct {
+	conf    OutboxConfig
+	wrapper *TgWrapper
+
+	mu       sync.Mutex
+	cond     *sync.Cond // Signaled when a message is queued or done, or the outbox is closed.
+	queue    []*outboxItem
+	inFlight map[int64]bool         // map[target chat id] = whether a message to the chat is being sent
+	byKey    map[string]*SendHandle // map[key] = the handle of the queued message with the key
+	sentKeys map[string]time.Time   // map[key] = when the message with the key was done
+	seq      uint64
+	closed   bool
+	closing  chan struct{} // Closed with closed, to stop waiting for redelivery.
+	wg       sync.WaitGroup
+}
+
+type outboxItem struct {
+	chat   *Chat
+	chatID int64 // The target chat, messages to the same chat are sent in order.
+	handle *SendHandle
+}
+
+// Start the outbox of the wrapper, used by SendTextMsgAsync(), SendTextMsgByComponentsAsync() and Enqueue().
+// If not started, the outbox is started with the default config on the first async send.
+// Returns the running outbox if already started.
+//
+// With a Store, the messages left by the last run are queued again,
+// so the chats sending them must be registered before starting the outbox.
+func (tg *TgWrapper) StartOutbox(conf OutboxConfig) *Outbox {
+	tg.botsMu.Lock()
+	defer tg.botsMu.Unlock()
+	if tg.outbox == nil {
+		tg.outbox = newOutbox(tg, conf)
+	}
+	return tg.outbox
+}
+
+// Stop accepting messages, and wait for the queued messages to be sent until ctx is done.
+// Messages not sent by then, and messages sent async after closing, fail with ErrOutboxClosed.
+func (tg *TgWrapper) CloseOutbox(ctx context.Context) error {
+	return tg.StartOutbox(OutboxConfig{}).Close(ctx)
+}
+
+func newOutbox(tg *TgWrapper, conf OutboxConfig) *Outbox {
+	if conf.Capacity <= 0 {
+		conf.Capacity = 1000
+	}
+	if conf.RedeliverInterval <= 0 {
+		conf.RedeliverInterval = 10 * time.Second
+	}
+	if conf.DedupWindow <= 0 {
+		conf.DedupWindow = 24 * time.Hour
+	}
+	conf.Workers = max(conf.Workers, 1)
+	outbox := &Outbox{
+		conf:     conf,
+		wrapper:  tg,
+		inFlight: make(map[int64]bool),
+		byKey:    make(map[string]*SendHandle),
+		sentKeys: make(map[string]time.Time),
+		closing:  make(chan struct{}),
+	}
+	outbox.cond = sync.NewCond(&outbox.mu)
+	outbox.restore()
+	for range conf.Workers {
+		outbox.wg.Add(1)
+		go outbox.work()
+	}
+	return outbox
+}
+
+// Queue the messages left in the store. Restored messages are not limited by the capacity.
+func (outbox *Outbox) restore() {
+	if outbox.conf.Store == nil {
+		return
+	}
+	logger := outbox.wrapper.getLogger()
+	pending, doneKeys, err := outbox.conf.Store.Load()
+	if err != nil {
+		logger.Error("tgx: load outbox failed", slog.String("error", err.Error()))
+		return
+	}
+	for key, doneAt := range doneKeys {
+		if time.Since(doneAt) < outbox.conf.DedupWindow {
+			outbox.sentKeys[key] = doneAt
+		}
+	}
+	for _, msg := range pending {
+		chat, err := outbox.wrapper.GetChat(msg.Chat)
+		if err != nil {
+			// Kept in the store, to be sent after the chat is registered and the outbox is started again.
+			logger.Error("tgx: restore outbox msg failed", slog.String("chat", msg.Chat), slog.String("id", msg.ID), slog.String("error", err.Error()))
+			continue
+		}
+		item := newOutboxItem(chat, msg)
+		outbox.queue = append(outbox.queue, item)
+		if msg.Key != "" {
+			outbox.byKey[msg.Key] = item.handle
+		}
+	}
+	if len(outbox.queue) > 0 {
+		logger.Info("tgx: outbox restored", slog.Int("msgs", len(outbox.queue)))
+	}
+}
+
+func newOutboxItem(chat *Chat, msg *OutboxMsg) *outboxItem {
+	chatID, _ := chat.decideChatAndTopic(msg.Target)
+	return &outboxItem{chat: chat, chatID: chatID, handle: &SendHandle{Msg: msg, done: make(chan struct{})}}
+}
+
+// Queue the message, see OverflowPolicy for a full outbox.
+func (outbox *Outbox) enqueue(chat *Chat, msg *OutboxMsg) *SendHandle {
+	msg.Chat = chat.Identifier
+	item := newOutboxItem(chat, msg)
+
+	outbox.mu.Lock()
+	if msg.Key != "" {
+		if handle, ok := outbox.byKey[msg.Key]; ok {
+			outbox.mu.Unlock()
+			return handle
+		}
+		if doneAt, ok := outbox.sentKeys[msg.Key]; ok && time.Since(doneAt) < outbox.conf.DedupWindow {
+			outbox.mu.Unlock()
+			outbox.finish(item, nil, tgxerrors.ErrOutboxDuplicate)
+			return item.handle
+		}
+	}
+	var dropped *outboxItem
+	for !outbox.closed && len(outbox.queue) >= outbox.conf.Capacity {
+		if outbox.conf.Overflow == OverflowDropNewest {
+			outbox.mu.Unlock()
+			outbox.finish(item, nil, tgxerrors.ErrOutboxFull)
+			return item.handle
+		}
+		if outbox.conf.Overflow == OverflowDropOldest {
+			dropped = outbox.queue[0]
+			outbox.queue = outbox.queue[1:]
+			outbox.discard(dropped)
+			break
+		}
+		outbox.cond.Wait()
+	}
+	if outbox.closed {
+		outbox.mu.Unlock()
+		outbox.finish(item, nil, tgxerrors.ErrOutboxClosed)
+		return item.handle
+	}
+	outbox.seq++
+	msg.ID = fmt.Sprintf("%d-%d", time.Now().UnixNano(), outbox.seq)
+	msg.Time = time.Now()
+	if outbox.conf.Store != nil {
+		// Saved under the lock, so the store has the messages in the order they are queued.
+		if err := outbox.conf.Store.Add(msg); err != nil {
+			outbox.mu.Unlock()
+			outbox.finish(item, nil, err)
+			return item.handle
+		}
+	}
+	outbox.queue = append(outbox.queue, item)
+	if msg.Key != "" {
+		outbox.byKey[msg.Key] = item.handle
+	}
+	outbox.cond.Broadcast()
+	outbox.mu.Unlock()
+
+	if dropped != nil {
+		outbox.finish(dropped, nil, tgxerrors.ErrOutboxDropped)
+	}
+	return item.handle
+}
+
+// Take the first queued message whose chat has no message being sent. Must hold mu.
+func (outbox *Outbox) next() *outboxItem {
+	for i, item := range outbox.queue {
+		if outbox.inFlight[item.chatID] {
+			continue
+		}
+		outbox.queue = append(outbox.queue[:i:i], outbox.queue[i+1:]...)
+		outbox.inFlight[item.chatID] = true
+		return item
+	}
+	return nil
+}
+
+func (outbox *Outbox) work() {
+	defer outbox.wg.Done()
+	for {
+		outbox.mu.Lock()
+		item := outbox.next()
+		for item == nil {
+			if outbox.closed && len(outbox.queue) == 0 {
+				outbox.mu.Unlock()
+				return
+			}
+			outbox.cond.Wait()
+			item = outbox.next()
+		}
+		outbox.mu.Unlock()
+
+		msgsSent, err := item.send()
+		if err != nil && outbox.shouldRedeliver(err) {
+			outbox.redeliver(item, err)
+			continue
+		}
+
+		outbox.mu.Lock()
+		outbox.forget(item)
+		outbox.mu.Unlock()
+		outbox.finish(item, msgsSent, err)
+
+		outbox.mu.Lock()
+		delete(outbox.inFlight, item.chatID)
+		outbox.cond.Broadcast()
+		outbox.mu.Unlock()
+	}
+}
+
+func (item *outboxItem) send() ([]*tgbotapi.Message, error) {
+	msg := item.handle.Msg
+	if len(msg.Components) > 0 {
+		return item.chat.SendTextMsgByComponents(msg.Target, msg.Components...)
+	}
+	return item.chat.SendTextMsg(msg.Target, msg.Text)
+}
+
+// Whether the failed message should be sent again: only for durable outboxes, and temporary errors.
+func (outbox *Outbox) shouldRedeliver(err error) bool {
+	var requestErr *tgxerrors.RequestError
+	return outbox.conf.Store != nil && errors.As(err, &requestErr) && requestErr.Class != tgxerrors.ClassPermanent
+}
+
+// Put the message back to the front of the queue after RedeliverInterval,
+// keeping the chat blocked meanwhile so the messages to the chat stay in order.
+// If the outbox is closed, the message fails but is kept in the store, to be sent by the next run.
+func (outbox *Outbox) redeliver(item *outboxItem, err error) {
+	item.chat.getLogger().Warn("tgx: outbox send failed, redelivering",
+		append(item.chat.logAttrs(), slog.String("id", item.handle.Msg.ID), slog.Duration("wait", outbox.conf.RedeliverInterval), slog.String("error", item.chat.redact(err)))...)
+
+	timer := time.NewTimer(outbox.conf.RedeliverInterval)
+	select {
+	case <-timer.C:
+	case <-outbox.closing:
+		timer.Stop()
+	}
+
+	outbox.mu.Lock()
+	delete(outbox.inFlight, item.chatID)
+	if !outbox.closed {
+		outbox.queue = append([]*outboxItem{item}, outbox.queue...)
+		outbox.cond.Broadcast()
+		outbox.mu.Unlock()
+		return
+	}
+	delete(outbox.byKey, item.handle.Msg.Key)
+	outbox.cond.Broadcast()
+	outbox.mu.Unlock()
+	outbox.finish(item, nil, errors.Join(tgxerrors.ErrOutboxClosed, err))
+}
+
+// Remove the message from the store and remember its key. Must hold mu.
+func (outbox *Outbox) forget(item *outboxItem) {
+	msg := item.handle.Msg
+	if msg.Key != "" {
+		delete(outbox.byKey, msg.Key)
+		outbox.sentKeys[msg.Key] = time.Now()
+		for key, doneAt := range outbox.sentKeys {
+			if time.Since(doneAt) >= outbox.conf.DedupWindow {
+				delete(outbox.sentKeys, key)
+			}
+		}
+	}
+	if outbox.conf.Store != nil {
+		if err := outbox.conf.Store.Done(msg); err != nil {
+			item.chat.getLogger().Error("tgx: remove outbox msg failed", append(item.chat.logAttrs(), slog.String("id", msg.ID), slog.String("error", err.Error()))...)
+		}
+	}
+}
+
+// Remove the dropped message from the store, without remembering its key, so it can be queued again. Must hold mu.
+func (outbox *Outbox) discard(item *outboxItem) {
+	msg := item.handle.Msg
+	if msg.Key != "" {
+		delete(outbox.byKey, msg.Key)
+	}
+	if outbox.conf.Store != nil {
+		if err := outbox.conf.Store.Drop(msg); err != nil {
+			item.chat.getLogger().Error("tgx: remove outbox msg failed", append(item.chat.logAttrs(), slog.String("id", msg.ID), slog.String("error", err.Error()))...)
+		}
+	}
+}
+
+// Complete the handle and call OnResult. Must not hold mu.
+func (outbox *Outbox) finish(item *outboxItem, msgsSent []*tgbotapi.Message, err error) {
+	item.handle.msgsSent, item.handle.err = msgsSent, err
+	close(item.handle.done)
+	if err != nil && !errors.Is(err, tgxerrors.ErrOutboxDuplicate) {
+		item.chat.getLogger().Error("tgx: outbox send failed", append(item.chat.logAttrs(), slog.String("error", item.chat.redact(err)))...)
+	}
+	if outbox.conf.OnResult != nil {
+		outbox.conf.OnResult(item.handle.Msg, msgsSent, err)
+	}
+}
+
+// Len returns the number of queued messages, not including the ones being sent.
+func (outbox *Outbox) Len() int {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	return len(outbox.queue)
+}
+
+// Stop accepting messages, and wait for the queued messages to be sent until ctx is done.
+// Messages not sent by then fail with ErrOutboxClosed, and are kept in the store if durable.
+func (outbox *Outbox) Close(ctx context.Context) error {
+	outbox.mu.Lock()
+	if !outbox.closed {
+		outbox.closed = true
+		close(outbox.closing)
+	}
+	outbox.cond.Broadcast()
+	outbox.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		outbox.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		outbox.mu.Lock()
+		abandoned := outbox.queue
+		outbox.queue = nil
+		for _, item := range abandoned {
+			delete(outbox.byKey, item.handle.Msg.Key)
+		}
+		outbox.cond.Broadcast()
+		outbox.mu.Unlock()
+		for _, item := range abandoned {
+			outbox.finish(item, nil, tgxerrors.ErrOutboxClosed)
+		}
+		return ctx.Err()
+	}
+}
+
+// ========== Async Send ==========
+
+// Queue the message to the outbox of the wrapper.
+// Chats not created by a wrapper have no outbox, the handle fails with ErrNoOutbox.
+func (chat *Chat) enqueue(msg *OutboxMsg) *SendHandle {
+	if chat.wrapper == nil {
+		msg.Chat = chat.Identifier
+		handle := &SendHandle{Msg: msg, done: make(chan struct{}), err: tgxerrors.ErrNoOutbox}
+		close(handle.done)
+		return handle
+	}
+	return chat.wrapper.StartOutbox(OutboxConfig{}).enqueue(chat, msg)
+}
+
+// Same as SendTextMsg(), but returns at once, sending the message by the outbox of the wrapper.
+// Messages to the same chat are sent in the order they are queued.
+func (chat *Chat) SendTextMsgAsync(targetChatOverride *ChatAndTopic, text string) *SendHandle {
+	return chat.enqueue(&OutboxMsg{Target: targetChatOverride, Text: text})
+}
+
+// Same as SendTextMsgByComponents(), but returns at once, sending the message by the outbox of the wrapper.
+// Messages to the same chat are sent in the order they are queued.
+func (chat *Chat) SendTextMsgByComponentsAsync(targetChatOverride *ChatAndTopic, components ...[]MsgComponent) *SendHandle {
+	return chat.enqueue(&OutboxMsg{Target: targetChatOverride, Components: components})
+}
+
+// Queue the message to the outbox of the wrapper, sent by the chat. Set Target, Text or Components, and optionally Key.
+//
+// If Key is set, a message with the same key queued or sent within DedupWindow is not sent again:
+// the handle of the queued one is returned, or a handle failed with ErrOutboxDuplicate.
+func (chat *Chat) Enqueue(msg *OutboxMsg) *SendHandle {
+	return chat.enqueue(msg)
+}
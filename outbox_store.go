@@ -0,0 +1,208 @@
+package tgx
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The default number of journal records to compact at, see FileOutboxStore.SetCompactThreshold().
+const defaultOutboxCompactThreshold = 10000
+
+// FileOutboxStore keeps the outbox in a local journal file, one JSON record per line.
+// The journal is compacted when loaded, and when it grows past the compact threshold,
+// leaving only the pending messages and the recent keys.
+type FileOutboxStore struct {
+	path        string
+	dedupWindow time.Duration
+
+	mu               sync.Mutex
+	file             *os.File
+	records          int // The records in the journal.
+	liveRecords      int // The records left by the last compaction.
+	compactThreshold int
+}
+
+type outboxRecord struct {
+	Msg    *OutboxMsg `json:"msg,omitempty"`  // Added.
+	DoneID string     `json:"done,omitempty"` // Done, by the ID of the message.
+	Key    string     `json:"key,omitempty"`  // The key of the message done.
+	Time   time.Time  `json:"time"`
+}
+
+// Open the journal at path, creating it if not exist.
+// The keys of messages done are kept for dedupWindow, default 24 hours.
+func NewFileOutboxStore(path string, dedupWindow time.Duration) (*FileOutboxStore, error) {
+	if dedupWindow <= 0 {
+		dedupWindow = 24 * time.Hour
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	store := &FileOutboxStore{path: path, dedupWindow: dedupWindow, file: file, compactThreshold: defaultOutboxCompactThreshold}
+	return store, nil
+}
+
+// Set the number of records to compact the journal at, default 10000.
+// The threshold is raised to twice the records left by the last compaction, so a large backlog is not rewritten on every write.
+func (store *FileOutboxStore) SetCompactThreshold(records int) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.compactThreshold = max(records, 1)
+}
+
+func (store *FileOutboxStore) Add(msg *OutboxMsg) error {
+	return store.append(outboxRecord{Msg: msg, Time: time.Now()})
+}
+
+func (store *FileOutboxStore) Done(msg *OutboxMsg) error {
+	return store.append(outboxRecord{DoneID: msg.ID, Key: msg.Key, Time: time.Now()})
+}
+
+func (store *FileOutboxStore) Drop(msg *OutboxMsg) error {
+	return store.append(outboxRecord{DoneID: msg.ID, Time: time.Now()})
+}
+
+// Write the record and sync, so it survives a crash right after.
+// Failing to compact does not fail the write, the record is already in the journal.
+func (store *FileOutboxStore) append(record outboxRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, err = store.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = store.file.Sync(); err != nil {
+		return err
+	}
+	store.records++
+	if store.records < max(store.compactThreshold, 2*store.liveRecords) {
+		return nil
+	}
+	pending, doneKeys, err := store.read()
+	if err == nil {
+		err = store.compact(pending, doneKeys)
+	}
+	if err != nil {
+		// Try again once the journal doubles, instead of on every write.
+		store.liveRecords = store.records
+		slog.Default().Error("tgx: compact outbox journal failed", slog.String("path", store.path), slog.String("error", err.Error()))
+	}
+	return nil
+}
+
+func (store *FileOutboxStore) Load() (pending []*OutboxMsg, doneKeys map[string]time.Time, err error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if pending, doneKeys, err = store.read(); err != nil {
+		return nil, nil, err
+	}
+	return pending, doneKeys, store.compact(pending, doneKeys)
+}
+
+// Read the pending messages and the recent keys from the journal. Must hold mu.
+func (store *FileOutboxStore) read() (pending []*OutboxMsg, doneKeys map[string]time.Time, err error) {
+	file, err := os.Open(store.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var added []*OutboxMsg
+	done := make(map[string]bool)
+	doneKeys = make(map[string]time.Time)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		var record outboxRecord
+		// A line cut by a crash is skipped, its message is not acknowledged to the caller anyway.
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			continue
+		}
+		if record.Msg != nil {
+			added = append(added, record.Msg)
+			continue
+		}
+		done[record.DoneID] = true
+		if record.Key != "" && time.Since(record.Time) < store.dedupWindow {
+			doneKeys[record.Key] = record.Time
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	for _, msg := range added {
+		if !done[msg.ID] {
+			pending = append(pending, msg)
+		}
+	}
+	return pending, doneKeys, nil
+}
+
+// Rewrite the journal with only the pending messages and the recent keys. Must hold mu.
+func (store *FileOutboxStore) compact(pending []*OutboxMsg, doneKeys map[string]time.Time) error {
+	tmpPath := store.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = writeOutboxRecords(tmp, pending, doneKeys)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Rename(tmpPath, store.path); err != nil {
+		return err
+	}
+
+	// Reopen, the old file is replaced.
+	file, err := os.OpenFile(store.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	store.file.Close()
+	store.file = file
+	store.records = len(pending) + len(doneKeys)
+	store.liveRecords = store.records
+	return nil
+}
+
+// Close the journal file.
+func (store *FileOutboxStore) Close() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.file.Close()
+}
+
+func writeOutboxRecords(file *os.File, pending []*OutboxMsg, doneKeys map[string]time.Time) error {
+	encoder := json.NewEncoder(file)
+	for key, doneAt := range doneKeys {
+		if err := encoder.Encode(outboxRecord{DoneID: "-", Key: key, Time: doneAt}); err != nil {
+			return err
+		}
+	}
+	for _, msg := range pending {
+		if err := encoder.Encode(outboxRecord{Msg: msg, Time: msg.Time}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
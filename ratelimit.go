@@ -0,0 +1,143 @@
+package tgx
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/0xVanfer/tgx/internal/tgxutils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Count events per Per. A zero Rate means no limit.
+type Rate struct {
+	Count int           `json:"count" mapstructure:"count"`
+	Per   time.Duration `json:"per" mapstructure:"per"`
+}
+
+// The limits of sending messages, shared by all chats of the same bot.
+type RateLimits struct {
+	Bot     Rate `json:"bot" mapstructure:"bot"`         // All messages sent by the bot.
+	Private Rate `json:"private" mapstructure:"private"` // Messages sent to the same private chat.
+	Group   Rate `json:"group" mapstructure:"group"`     // Messages sent to the same group or channel.
+}
+
+// The limits suggested by Telegram: 30 messages per second per bot, 1 per second per private chat,
+// and 20 per minute per group.
+var DefaultRateLimits = RateLimits{
+	Bot:     Rate{Count: 30, Per: time.Second},
+	Private: Rate{Count: 1, Per: time.Second},
+	Group:   Rate{Count: 20, Per: time.Minute},
+}
+
+// Set the rate limits of sending messages, default DefaultRateLimits. Use RateLimits{} to disable.
+// Sends and edits exceeding the limits wait until they are allowed.
+// Should be set before sending.
+func (tg *TgWrapper) SetRateLimits(limits RateLimits) {
+	tg.botsMu.Lock()
+	defer tg.botsMu.Unlock()
+	tg.rateLimits = &limits
+	tg.limiters.Clear()
+}
+
+// How often the full buckets of the chats are dropped, so a bot sending to many chats does not keep them all.
+const chatBucketSweepInterval = time.Minute
+
+// The rate limiter of a bot, with a global bucket and a bucket for each chat.
+type rateLimiter struct {
+	limits RateLimits
+	bot    *tgxutils.TokenBucket
+
+	chatsMu sync.Mutex
+	chats   map[int64]*tgxutils.TokenBucket
+	swept   time.Time // When the full buckets of the chats were dropped last time.
+}
+
+func (tg *TgWrapper) getRateLimiter(botToken string) *rateLimiter {
+	if limiter, ok := tg.limiters.Load(botToken); ok {
+		return limiter.(*rateLimiter)
+	}
+	// Created under the lock of SetRateLimits(), so a limiter of the old limits is not stored after the limits change.
+	tg.botsMu.Lock()
+	defer tg.botsMu.Unlock()
+	limits := DefaultRateLimits
+	if tg.rateLimits != nil {
+		limits = *tg.rateLimits
+	}
+	limiter, _ := tg.limiters.LoadOrStore(botToken, &rateLimiter{
+		limits: limits,
+		bot:    tgxutils.NewTokenBucket(limits.Bot.Count, limits.Bot.Per),
+	})
+	return limiter.(*rateLimiter)
+}
+
+// Wait until a message can be sent to the chat, or ctx is done. Returns the time waited.
+func (limiter *rateLimiter) wait(ctx context.Context, chatID int64) (time.Duration, error) {
+	var waited time.Duration
+	if chatID != 0 {
+		wait := limiter.reserveChat(chatID, time.Now())
+		if err := tgxutils.Sleep(ctx, wait); err != nil {
+			return wait, err
+		}
+		waited += wait
+	}
+	// Wait for the chat first, so the bot's tokens are not held while waiting.
+	wait, err := limiter.bot.Wait(ctx)
+	return waited + wait, err
+}
+
+// Take a token of the chat, returning how long to wait before using it.
+func (limiter *rateLimiter) reserveChat(chatID int64, now time.Time) time.Duration {
+	limiter.chatsMu.Lock()
+	defer limiter.chatsMu.Unlock()
+
+	if now.Sub(limiter.swept) >= chatBucketSweepInterval {
+		// A full bucket is the same as a new one, dropping it does not change the limits.
+		for id, bucket := range limiter.chats {
+			if bucket.FullAt(now) {
+				delete(limiter.chats, id)
+			}
+		}
+		limiter.swept = now
+	}
+
+	bucket, ok := limiter.chats[chatID]
+	if !ok {
+		// Group and channel IDs are negative.
+		rate := limiter.limits.Private
+		if chatID < 0 {
+			rate = limiter.limits.Group
+		}
+		bucket = tgxutils.NewTokenBucket(rate.Count, rate.Per)
+		if limiter.chats == nil {
+			limiter.chats = make(map[int64]*tgxutils.TokenBucket)
+		}
+		limiter.chats[chatID] = bucket
+	}
+	return bucket.ReserveAt(now)
+}
+
+// Wait until the message can be sent by the rate limits of the bot, or ctx is done.
+// Requests without a target chat, e.g. setMyCommands, wait for the bot's limit only.
+func (chat *Chat) waitRateLimit(ctx context.Context, c tgbotapi.Chattable) error {
+	if chat.wrapper == nil || chat.Bot == nil {
+		return nil
+	}
+	var chatID int64
+	switch c := c.(type) {
+	case tgbotapi.MessageConfig:
+		chatID = c.ChatID
+	case tgbotapi.PhotoConfig:
+		chatID = c.ChatID
+	case tgbotapi.EditMessageTextConfig:
+		chatID = c.ChatID
+	case tgbotapi.DeleteMessageConfig:
+		chatID = c.ChatID
+	}
+	waited, err := chat.wrapper.getRateLimiter(chat.Bot.Token()).wait(ctx, chatID)
+	if waited > 0 {
+		chat.getLogger().Debug("tgx: rate limited", append(chat.logAttrs(), slog.Int64("target_chat_id", chatID), slog.Duration("wait", waited))...)
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+package tgx
+
+import (
+	"sync"
+	"time"
+
+	"github.com/0xVanfer/tgx/tgxerrors"
+)
+
+// A bot in the registry of the wrapper. The bot is created once, and shared by all chats of the token.
+type registeredBot struct {
+	token string
+	ready chan struct{} // Closed once the bot is created or failed.
+	bot   BotAPI
+	err   error
+
+	healthMu sync.Mutex
+	health   BotHealth
+}
+
+// Get the bot of the token from the registry, creating it by the conf if not yet.
+// Concurrent registrations of the same token wait for the same creation, so the token is validated once.
+//
+// The bot is created with the conf of the first chat of the token,
+// the Bot, APIEndpoint and HTTPClient of later chats of the same token are ignored.
+func (tg *TgWrapper) loadOrCreateBot(conf SingleChatConf) (BotAPI, error) {
+	entry := &registeredBot{token: conf.BotToken, ready: make(chan struct{})}
+	if loaded, ok := tg.bots.LoadOrStore(conf.BotToken, entry); ok {
+		existing := loaded.(*registeredBot)
+		<-existing.ready
+		if existing.err == nil {
+			return existing.bot, nil
+		}
+		// The creation failed, try again with this conf.
+		tg.bots.CompareAndDelete(conf.BotToken, existing)
+		return tg.loadOrCreateBot(conf)
+	}
+
+	if tg.lazy && conf.Bot == nil {
+		lazy := newLazyBot(conf.BotToken)
+		entry.bot = lazy
+		entry.health = BotHealth{Status: BotConnecting, Since: time.Now()}
+		close(entry.ready)
+		go tg.connect(conf, entry, lazy)
+		return entry.bot, nil
+	}
+
+	entry.bot, entry.err = tg.newBotAPI(conf)
+	entry.health = BotHealth{Status: BotConnected, Since: time.Now()}
+	close(entry.ready)
+	if entry.err != nil {
+		tg.bots.CompareAndDelete(conf.BotToken, entry)
+	}
+	return entry.bot, entry.err
+}
+
+// Remove the bot of the token from the registry, stopping connecting it if lazily registered.
+// The rate limiter of the bot is removed too.
+func (tg *TgWrapper) removeBot(botToken string) {
+	tg.limiters.Delete(botToken)
+	value, ok := tg.bots.LoadAndDelete(botToken)
+	if !ok {
+		return
+	}
+	if lazy, ok := value.(*registeredBot).bot.(*lazyBot); ok {
+		lazy.stop()
+	}
+}
+
+// The bot of the token in the registry, nil if not created.
+func (tg *TgWrapper) getBot(botToken string) BotAPI {
+	value, ok := tg.bots.Load(botToken)
+	if !ok {
+		return nil
+	}
+	entry := value.(*registeredBot)
+	select {
+	case <-entry.ready:
+		return entry.bot
+	default:
+		return nil
+	}
+}
+
+// The information of a registered bot.
+type BotMeta struct {
+	ID                      int64
+	UserName                string
+	FirstName               string
+	CanJoinGroups           bool
+	CanReadAllGroupMessages bool
+	SupportsInlineQueries   bool
+
+	Chats []string // The identifiers of the chats of the bot, in the order registered.
+}
+
+// Get the information of the bot by token, as returned by getMe when the bot is registered.
+func (tg *TgWrapper) GetBotMeta(botToken string) (*BotMeta, error) {
+	info := tg.getBotInfo(botToken)
+	if info == nil || info.Bot == nil {
+		return nil, tgxerrors.ErrBotNotFound
+	}
+	if lazy, ok := info.Bot.(*lazyBot); ok && !lazy.connected() {
+		return nil, tgxerrors.ErrBotNotReady
+	}
+	self := info.Bot.Self()
+	meta := &BotMeta{
+		ID:                      self.ID,
+		UserName:                self.UserName,
+		FirstName:               self.FirstName,
+		CanJoinGroups:           self.CanJoinGroups,
+		CanReadAllGroupMessages: self.CanReadAllGroupMessages,
+		SupportsInlineQueries:   self.SupportsInlineQueries,
+	}
+	for _, chat := range info.Chats {
+		meta.Chats = append(meta.Chats, chat.Identifier)
+	}
+	return meta, nil
+}
+
+// ========== Health ==========
+
+// The connection state of a bot.
+type BotStatus int
+
+const (
+	BotConnecting BotStatus = iota // Lazily registered, calling getMe until it succeeds.
+	BotConnected                   // getMe succeeded.
+	BotFailed                      // The token is rejected by Telegram, no more attempts.
+)
+
+func (s BotStatus) String() string {
+	switch s {
+	case BotConnecting:
+		return "connecting"
+	case BotConnected:
+		return "connected"
+	case BotFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// The health of a registered bot.
+type BotHealth struct {
+	Status BotStatus
+	Since  time.Time // When the status changed.
+
+	// The last error of connecting or getting updates, nil once they succeed again.
+	// The bot token is redacted from the error, so it is safe to expose.
+	LastError     error
+	LastErrorTime time.Time
+	Failures      int // The consecutive failures of connecting or getting updates.
+}
+
+func (r *registeredBot) setStatus(status BotStatus, err error) {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+
+	if r.health.Status != status {
+		r.health.Status = status
+		r.health.Since = time.Now()
+	}
+	r.setError(err)
+}
+
+func (r *registeredBot) reportError(err error) {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+	r.setError(err)
+}
+
+func (r *registeredBot) setError(err error) {
+	if err == nil {
+		r.health.LastError = nil
+		r.health.Failures = 0
+		return
+	}
+	r.health.LastError = redactError(err, r.token)
+	r.health.LastErrorTime = time.Now()
+	r.health.Failures++
+}
+
+// Record the result of a request of the bot in its health.
+func (tg *TgWrapper) reportBotError(botToken string, err error) {
+	if value, ok := tg.bots.Load(botToken); ok {
+		value.(*registeredBot).reportError(err)
+	}
+}
+
+// Get the health of the bot by token.
+func (tg *TgWrapper) GetBotHealth(botToken string) (BotHealth, error) {
+	value, ok := tg.bots.Load(botToken)
+	if !ok {
+		return BotHealth{}, tgxerrors.ErrBotNotFound
+	}
+	entry := value.(*registeredBot)
+	<-entry.ready
+	if entry.err != nil {
+		return BotHealth{}, tgxerrors.ErrBotNotFound
+	}
+	entry.healthMu.Lock()
+	defer entry.healthMu.Unlock()
+	return entry.health, nil
+}
+
+// Get the health of all registered bots, map[bot token]health.
+func (tg *TgWrapper) GetAllBotHealth() map[string]BotHealth {
+	healths := make(map[string]BotHealth)
+	for botToken := range tg.GetAllRegisteredBots() {
+		if health, err := tg.GetBotHealth(botToken); err == nil {
+			healths[botToken] = health
+		}
+	}
+	return healths
+}
@@ -0,0 +1,162 @@
+package tgx
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/0xVanfer/tgx/tgxerrors"
+)
+
+// ApplyConfig applies the config to the running wrapper, without restarting it:
+//
+// - Chats added to the config are registered.
+// - Chats removed from the config are unregistered, their managed messages are kept in Telegram.
+// - Chats with changed ChatID, ChatTopic or Description are updated in place, keeping their handlers and managed messages.
+// - Chats with a changed bot token, endpoint or BotAPI are registered again, losing their handlers and managed messages.
+//
+// Only the chats applied by the config before are unregistered, chats registered by RegisterChat() are not touched.
+// The chat level settings are applied to all chats of the config, and the rate limits to the wrapper.
+// APIEndpoint, FileEndpoint and LazyRegistration are only applied by InitWithConfig().
+//
+// The config is validated first, nothing is applied if it is invalid.
+// Chats failing to register are skipped and retried by the next ApplyConfig().
+func (tg *TgWrapper) ApplyConfig(conf *Config) error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	tg.configMu.Lock()
+	defer tg.configMu.Unlock()
+
+	tg.botsMu.Lock()
+	limitsChanged := conf.RateLimits != nil && (tg.rateLimits == nil || *tg.rateLimits != *conf.RateLimits)
+	tg.botsMu.Unlock()
+	if limitsChanged {
+		tg.SetRateLimits(*conf.RateLimits)
+	}
+
+	// Compare the chat IDs and topics given by the links.
+	chats := make([]SingleChatConf, len(conf.Chats))
+	applied := make(map[string]SingleChatConf, len(conf.Chats))
+	for i, c := range conf.Chats {
+		chats[i], _ = c.resolveLink()
+		applied[c.Identifier] = chats[i]
+	}
+
+	var errs []error
+	var added, removed, updated int
+	// Remove first, so the identifiers can be registered again.
+	for identifier, old := range tg.configChats {
+		if c, ok := applied[identifier]; ok && !needsReregister(old, c) {
+			continue
+		}
+		if err := tg.UnregisterChat(identifier, false); err != nil && !errors.Is(err, tgxerrors.ErrIdentifierNotFound) {
+			errs = append(errs, err)
+			continue
+		}
+		delete(tg.configChats, identifier)
+		removed++
+	}
+
+	if tg.configChats == nil {
+		tg.configChats = make(map[string]SingleChatConf)
+	}
+	for _, c := range chats {
+		old, exist := tg.configChats[c.Identifier]
+		if !exist {
+			chat, err := tg.RegisterChat(c)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			tg.configChats[c.Identifier] = c
+			conf.applyTo(chat)
+			added++
+			continue
+		}
+
+		chat, err := tg.GetChat(c.Identifier)
+		if err != nil {
+			// Unregistered by UnregisterChat() meanwhile.
+			delete(tg.configChats, c.Identifier)
+			errs = append(errs, err)
+			continue
+		}
+		if old.ChatID != c.ChatID || old.ChatTopic != c.ChatTopic || old.Description != c.Description {
+			chat.setTarget(c.ChatID, c.ChatTopic, c.Description)
+			updated++
+		}
+		tg.configChats[c.Identifier] = c
+		conf.applyTo(chat)
+	}
+
+	tg.getLogger().Info("tgx: config applied",
+		slog.Int("added", added),
+		slog.Int("removed", removed),
+		slog.Int("updated", updated),
+		slog.Int("failed", len(errs)),
+	)
+	return errors.Join(errs...)
+}
+
+// Whether the chat must be registered again to apply the conf, since its bot changes.
+func needsReregister(old SingleChatConf, c SingleChatConf) bool {
+	return old.BotToken != c.BotToken ||
+		old.APIEndpoint != c.APIEndpoint ||
+		old.FileEndpoint != c.FileEndpoint ||
+		old.HTTPClient != c.HTTPClient ||
+		old.Bot != c.Bot
+}
+
+// WatchConfig loads the config file by LoadConfig() and applies it by ApplyConfig(),
+// then checks the file every interval and applies it again when it is modified, until stop is called.
+//
+// Errors of the first load are returned. Errors while watching are logged, keeping the current chats.
+func (tg *TgWrapper) WatchConfig(path string, interval time.Duration) (stop func(), err error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = tg.ApplyConfig(conf); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			latest, err := os.Stat(path)
+			if err != nil {
+				tg.getLogger().Error("tgx: watch config failed", slog.String("path", path), slog.String("error", err.Error()))
+				continue
+			}
+			if latest.ModTime().Equal(stat.ModTime()) && latest.Size() == stat.Size() {
+				continue
+			}
+			stat = latest
+
+			conf, err := LoadConfig(path)
+			if err == nil {
+				err = tg.ApplyConfig(conf)
+			}
+			if err != nil {
+				tg.getLogger().Error("tgx: reload config failed", slog.String("path", path), slog.String("error", err.Error()))
+			}
+		}
+	}()
+	return sync.OnceFunc(func() { close(done) }), nil
+}
@@ -0,0 +1,229 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	"github.com/0xVanfer/tgx/tgxtest"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// A command message sent to the fake chat.
+func commandMsg(text string) *tgbotapi.Message {
+	command, _, _ := strings.Cut(text, " ")
+	return &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: 42, UserName: "alice"},
+		Chat:      &tgbotapi.Chat{ID: fakeChatID, Type: "supergroup"},
+		Text:      text,
+		Entities:  []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	srv, _, chat := newFakeChat(t)
+	var args *tgx.CommandArgs
+	handle := func(ctx *tgx.Context) error {
+		args = ctx.Args()
+		return nil
+	}
+	chat.RegisterCommand(tgx.Command{
+		Name: "send",
+		Args: []tgx.CommandArg{{Name: "amount", Type: tgx.ArgInt}, {Name: "memo", Optional: true}},
+		Flags: []tgx.CommandFlag{
+			{Name: "dry-run", Type: tgx.ArgBool},
+			{Name: "fee", Type: tgx.ArgFloat, Default: "0.5"},
+			{Name: "wait", Type: tgx.ArgDuration},
+		},
+		Handle: handle,
+	})
+	chat.RegisterCommand(tgx.Command{
+		Name:   "say",
+		Args:   []tgx.CommandArg{{Name: "to"}, {Name: "text", Optional: true, Rest: true}},
+		Handle: handle,
+	})
+	chat.RegisterCommand(tgx.Command{
+		Name:   "remind",
+		Args:   []tgx.CommandArg{{Name: "in", Type: tgx.ArgDuration}, {Name: "verb"}, {Name: "what"}},
+		Handle: handle,
+	})
+
+	tests := []struct {
+		text string
+		want map[string]any // map[name] = value, nil for not given.
+	}{
+		{`/send 10`, map[string]any{"amount": int64(10), "memo": nil, "fee": 0.5, "dry-run": nil}},
+		{`/send 10 "for lunch"`, map[string]any{"memo": "for lunch"}},
+		{`/send 10 “for lunch”`, map[string]any{"memo": "for lunch"}},
+		{`/send 10 'for "lunch"'`, map[string]any{"memo": `for "lunch"`}},
+		{`/send 10 for\ lunch`, map[string]any{"memo": "for lunch"}},
+		{`/send 10 "say \"hi\""`, map[string]any{"memo": `say "hi"`}},
+		{`/send 10 C:\dir`, map[string]any{"memo": `C:\dir`}},
+		{`/send 10 "unterminated memo`, map[string]any{"memo": "unterminated memo"}},
+		{`/send 10 it's --dry-run`, map[string]any{"memo": "it's", "dry-run": true}},
+		{`/remind 10m don't forget`, map[string]any{"in": 10 * time.Minute, "verb": "don't", "what": "forget"}},
+		{`/send 10 --dry-run --fee=1.5 --wait 1m30s`, map[string]any{"dry-run": true, "fee": 1.5, "wait": 90 * time.Second}},
+		{`/send --DRY-RUN=false 10`, map[string]any{"amount": int64(10), "dry-run": false}},
+		{`/send 10 -- --memo`, map[string]any{"memo": "--memo"}},
+		{`/send 10 "--memo"`, map[string]any{"memo": "--memo"}},
+		{`/say bob hello  world --loud`, map[string]any{"to": "bob", "text": "hello  world --loud"}},
+		{`/say bob "  quoted  "`, map[string]any{"text": "  quoted  "}},
+		{`/say bob "hi" there`, map[string]any{"text": `"hi" there`}},
+		{`/say bob`, map[string]any{"to": "bob", "text": nil}},
+	}
+	for _, test := range tests {
+		args = nil
+		if err := chat.HandleCommand(commandMsg(test.text)); err != nil {
+			t.Errorf("%s: %v", test.text, err)
+			continue
+		}
+		if args == nil {
+			t.Errorf("%s: handler not called, replied %v", test.text, srv.Requests("sendMessage"))
+			continue
+		}
+		for name, want := range test.want {
+			var got any
+			switch want.(type) {
+			case int64:
+				got = args.Int(name)
+			case float64:
+				got = args.Float(name)
+			case bool:
+				got = args.Bool(name)
+			case time.Duration:
+				got = args.Duration(name)
+			case string:
+				got = args.String(name)
+			case nil:
+				if args.Has(name) {
+					got = "given"
+				}
+			}
+			if got != want {
+				t.Errorf("%s: expected %s %#v, got %#v", test.text, name, want, got)
+			}
+		}
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	srv, _, chat := newFakeChat(t)
+	chat.RegisterCommand(tgx.Command{
+		Name: "send",
+		Args: []tgx.CommandArg{{Name: "amount", Type: tgx.ArgInt}, {Name: "memo", Optional: true}},
+		Flags: []tgx.CommandFlag{
+			{Name: "dry-run", Type: tgx.ArgBool},
+			{Name: "fee", Type: tgx.ArgFloat},
+			{Name: "wait", Type: tgx.ArgDuration},
+		},
+		Handle: func(ctx *tgx.Context) error {
+			t.Errorf("unexpected call with %q", ctx.Args().Raw())
+			return nil
+		},
+	})
+
+	tests := []struct {
+		text string
+		err  error
+	}{
+		{`/send`, tgxerrors.ErrCommandMissingArg},
+		{`/send ten`, tgxerrors.ErrCommandInvalidArg},
+		{`/send 1.5`, tgxerrors.ErrCommandInvalidArg},
+		{`/send 10 a b`, tgxerrors.ErrCommandTooManyArgs},
+		{`/send 10 --fee x`, tgxerrors.ErrCommandInvalidArg},
+		{`/send 10 --dry-run=maybe`, tgxerrors.ErrCommandInvalidArg},
+		{`/send 10 --wait 5`, tgxerrors.ErrCommandInvalidArg},
+		{`/send 10 --wait`, tgxerrors.ErrCommandMissingArg},
+		{`/send 10 --unknown`, tgxerrors.ErrCommandUnknownFlag},
+	}
+	for i, test := range tests {
+		if err := chat.HandleCommand(commandMsg(test.text)); err != nil {
+			t.Errorf("%s: %v", test.text, err)
+			continue
+		}
+		sent := srv.Requests("sendMessage")
+		if len(sent) != i+1 {
+			t.Errorf("%s: expected the usage replied", test.text)
+			continue
+		}
+		text := sent[i].Params.Get("text")
+		if !strings.HasPrefix(text, strings.TrimPrefix(test.err.Error(), "tgx: ")) {
+			t.Errorf("%s: expected %v, got %q", test.text, test.err, text)
+		}
+		if !strings.HasSuffix(text, "Usage: /send <amount:int> [memo] [--dry-run] [--fee <float>] [--wait <duration>]") {
+			t.Errorf("%s: expected the usage, got %q", test.text, text)
+		}
+	}
+}
+
+func TestCommandOtherBot(t *testing.T) {
+	_, _, chat := newFakeChat(t)
+	var calls int
+	chat.RegisterCommand(tgx.Command{Name: "Ping", Aliases: []string{"p"}, Handle: func(ctx *tgx.Context) error {
+		calls++
+		return nil
+	}})
+	for _, text := range []string{"/ping", "/PING@tgxtest_bot", "/p", "/ping@other_bot", "/pong"} {
+		if err := chat.HandleCommand(commandMsg(text)); err != nil {
+			t.Errorf("%s: %v", text, err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestCommandReregister(t *testing.T) {
+	_, _, chat := newFakeChat(t)
+	var calls []string
+	record := func(name string) tgx.Handler {
+		return func(ctx *tgx.Context) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	chat.RegisterCommand(tgx.Command{Name: "ping", Aliases: []string{"p", "pi"}, Handle: record("first")})
+	chat.RegisterCommand(tgx.Command{Name: "ping", Aliases: []string{"pi"}, Handle: record("second")})
+	for _, text := range []string{"/ping", "/p", "/pi"} {
+		if err := chat.HandleCommand(commandMsg(text)); err != nil {
+			t.Errorf("%s: %v", text, err)
+		}
+	}
+	// The aliases of the command replaced are removed.
+	if fmt.Sprint(calls) != "[second second]" {
+		t.Errorf("unexpected calls %v", calls)
+	}
+}
+
+func TestCommandLazyBot(t *testing.T) {
+	srv := tgxtest.NewServer()
+	defer srv.Close()
+	// Not connected while getMe is held, so the username of the bot is unknown.
+	release := srv.Hold("getMe")
+	defer release()
+
+	tg := &tgx.TgWrapper{}
+	tg.SetLazyRegistration(true)
+	tg.SetBotFactory(srv.BotFactory())
+	chat, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, ChatID: fakeChatID, ChatTopic: -1, Identifier: "lazy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterChat("lazy", false)
+
+	var calls int
+	chat.RegisterHandleCommand("ping", func(ctx *tgx.Context) error {
+		calls++
+		return nil
+	})
+	if err = chat.HandleCommand(commandMsg("/ping@tgxtest_bot")); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("expected the command handled, got %d calls", calls)
+	}
+}
@@ -0,0 +1,182 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	"github.com/0xVanfer/tgx/tgxtest"
+)
+
+func writeConfig(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("TG_TOKEN", "123456:secret")
+	t.Setenv("TGX_RETRY", "5")
+	t.Setenv("TGX_CHATS_1_CHAT_TOPIC", "-1")
+
+	files := map[string]string{
+		"tgx.yaml": `
+retry: 2
+retry_interval: 1.5s
+disable_web_page_preview: false
+rate_limits:
+  bot: {count: 10, per: 1s}
+  group: {count: 20, per: 1m}
+chats:
+  - bot_token: ${env:TG_TOKEN}
+    chat_id: -1001234567890
+    chat_topic: 2
+    identifier: alerts
+  - bot_token: ${file:token}
+    chat_id: -1001234567890
+    identifier: logs
+`,
+		"tgx.toml": `
+retry = 2
+retry_interval = 1.5
+disable_web_page_preview = false
+[rate_limits]
+bot = {count = 10, per = "1s"}
+group = {count = 20, per = "1m"}
+[[chats]]
+bot_token = "${env:TG_TOKEN}"
+chat_id = -1001234567890
+chat_topic = 2
+identifier = "alerts"
+[[chats]]
+bot_token = "${file:token}"
+chat_id = -1001234567890
+identifier = "logs"
+`,
+		"tgx.json": `{
+	"retry": 2, "retry_interval": "1500ms", "disable_web_page_preview": false,
+	"rate_limits": {"bot": {"count": 10, "per": "1s"}, "group": {"count": 20, "per": 60}},
+	"chats": [
+		{"bot_token": "${env:TG_TOKEN}", "chat_id": -1001234567890, "chat_topic": 2, "identifier": "alerts"},
+		{"bot_token": "${file:token}", "chat_id": -1001234567890, "identifier": "logs"}
+	]
+}`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, name, content)
+			if err := os.WriteFile(filepath.Join(filepath.Dir(path), "token"), []byte("654321:file\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			conf, err := tgx.LoadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *conf.Retry != 5 || *conf.RetryInterval != 1500*time.Millisecond || *conf.DisableWebPagePreview {
+				t.Errorf("unexpected settings %d %s %v", *conf.Retry, *conf.RetryInterval, *conf.DisableWebPagePreview)
+			}
+			if conf.RateLimits.Bot != (tgx.Rate{Count: 10, Per: time.Second}) || conf.RateLimits.Group != (tgx.Rate{Count: 20, Per: time.Minute}) {
+				t.Errorf("unexpected rate limits %+v", conf.RateLimits)
+			}
+			if len(conf.Chats) != 2 {
+				t.Fatalf("expected 2 chats, got %d", len(conf.Chats))
+			}
+			alerts, logs := conf.Chats[0], conf.Chats[1]
+			if alerts.BotToken != "123456:secret" || alerts.ChatID != -1001234567890 || alerts.ChatTopic != 2 || alerts.Identifier != "alerts" {
+				t.Errorf("unexpected chat %+v", alerts)
+			}
+			if logs.BotToken != "654321:file" || logs.ChatTopic != -1 {
+				t.Errorf("unexpected chat %+v", logs)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	path := writeConfig(t, "tgx.yaml", `
+retry: -1
+rate_limits:
+  private: {count: 1}
+chats:
+  - bot_token: ${env:TGX_TEST_MISSING}
+    identifier: a
+  - bot_token: not-a-token
+    identifier: a
+    chat_topic: -2
+    unknown: 1
+`)
+	_, err := tgx.LoadConfig(path)
+	if !errors.Is(err, tgxerrors.ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	want := "tgx: config chats[0].bot_token: environment variable TGX_TEST_MISSING is not set"
+	if err.Error() != want {
+		t.Errorf("expected the secret error first, got %v", err)
+	}
+
+	t.Setenv("TGX_TEST_MISSING", "123:abc")
+	_, err = tgx.LoadConfig(path)
+	want = "tgx: config chats[1].unknown: unknown key"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected the unknown key, got %v", err)
+	}
+
+	conf := &tgx.Config{
+		Retry:      new(int),
+		RateLimits: &tgx.RateLimits{Private: tgx.Rate{Count: 1}},
+		Chats: []tgx.SingleChatConf{
+			{BotToken: "123:abc", Identifier: "a"},
+			{BotToken: "not-a-token", Identifier: "a", ChatTopic: -2},
+		},
+	}
+	*conf.Retry = -1
+	err = conf.Validate()
+	for _, target := range []error{tgxerrors.ErrInvalidBotToken, tgxerrors.ErrIdentifierAlreadyExists} {
+		if !errors.Is(err, target) {
+			t.Errorf("expected %v in %v", target, err)
+		}
+	}
+	want = `tgx: config chats[1].bot_token: bot_token is invalid
+tgx: config chats[1].chat_topic: -2 is not a topic, use -1 for the entire chat
+tgx: config chats[1].identifier: identifier already exists: "a" is used by chats[0]
+tgx: config rate_limits.private: 1 per 0s is not a rate
+tgx: config retry: -1 is negative`
+	if err == nil || err.Error() != want {
+		t.Errorf("unexpected errors:\n%v", err)
+	}
+}
+
+func TestInitWithConfig(t *testing.T) {
+	srv := tgxtest.NewServer()
+	defer srv.Close()
+	conf := &tgx.Config{
+		Retry:            new(int),
+		LazyRegistration: true,
+		APIEndpoint:      srv.URL,
+		Chats:            []tgx.SingleChatConf{{BotToken: srv.Token, ChatID: fakeChatID, Identifier: "configured"}},
+	}
+	tg, err := tgx.InitWithConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterChat("configured", false)
+
+	// A failure is not retried with retry 0.
+	srv.Fail("sendMessage", tgxtest.ServerError())
+	chat, _ := tg.GetChat("configured")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err = chat.SendTextMsgContext(ctx, nil, "failed"); !errors.Is(err, tgxerrors.ErrRetryable) {
+		t.Errorf("expected the failure, got %v", err)
+	}
+	if n := len(srv.Requests("sendMessage")); n != 1 {
+		t.Errorf("expected no retry, got %d attempts", n)
+	}
+}
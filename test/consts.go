@@ -1,6 +1,9 @@
 package test
 
-import "github.com/0xVanfer/tgx"
+import (
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxtest"
+)
 
 var (
 	wrapper *tgx.TgWrapper
@@ -10,11 +13,25 @@ var (
 	msgTopicChat      *tgx.Chat
 	monitorTopicChat  *tgx.Chat
 	monitorEverywhere *tgx.Chat
+
+	// The fake Bot API server used if BotToken is empty, so the tests run without network.
+	fakeServer *tgxtest.Server
 )
 
 func init() {
+	confs := TestChats
+	if BotToken == "" {
+		fakeServer = tgxtest.NewServer()
+		bot := fakeServer.Bot()
+		confs = nil
+		for _, conf := range TestChats {
+			conf.Bot = bot
+			confs = append(confs, conf)
+		}
+	}
+
 	var err error
-	wrapper, err = tgx.Init(TestChats...)
+	wrapper, err = tgx.Init(confs...)
 	if err != nil {
 		panic(err)
 	}
@@ -41,7 +58,7 @@ func init() {
 	}
 }
 
-// Change to your own bot token
+// Change to your own bot token. Leave it empty to test with the fake server.
 var BotToken = ""
 
 const (
@@ -0,0 +1,70 @@
+package test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/0xVanfer/tgx"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestContext(t *testing.T) {
+	srv, _, chat := newFakeChat(t)
+	chat.RegisterHandleMsg("context", func(ctx *tgx.Context) error {
+		if raw := ctx.Args().Raw(); raw != "hello" {
+			t.Errorf("expected the text as the raw args, got %q", raw)
+		}
+		if target := ctx.Target(); target.ChatID != fakeChatID || target.ChatTopic != 7 {
+			t.Errorf("unexpected target %+v", target)
+		}
+		replies, err := ctx.Reply("pong")
+		if err != nil {
+			return err
+		}
+		if err = ctx.Edit(replies[0].MessageID, "pong edited"); err != nil {
+			return err
+		}
+		return ctx.Ack("")
+	})
+	msg := &tgbotapi.Message{
+		MessageID:      21,
+		From:           &tgbotapi.User{ID: 42, UserName: "alice"},
+		Chat:           &tgbotapi.Chat{ID: fakeChatID, Type: "supergroup"},
+		Text:           "hello",
+		ReplyToMessage: &tgbotapi.Message{MessageID: 7},
+	}
+	if errs := chat.HandleMsg(msg); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	// Replied in the topic of the update.
+	sent := srv.Requests("sendMessage")
+	if len(sent) != 1 || sent[0].Params.Get("reply_to_message_id") != "7" || sent[0].Params.Get("chat_id") != strconv.FormatInt(fakeChatID, 10) {
+		t.Fatalf("unexpected replies %+v", sent)
+	}
+	if msgs := srv.Messages(fakeChatID); len(msgs) != 1 || msgs[0].Text != "pong edited" {
+		t.Errorf("expected the reply edited, got %+v", msgs)
+	}
+	reactions := srv.Requests("setMessageReaction")
+	if len(reactions) != 1 || reactions[0].Params.Get("message_id") != "21" || !strings.Contains(reactions[0].Params.Get("reaction"), "👍") {
+		t.Errorf("unexpected reactions %+v", reactions)
+	}
+}
+
+func TestContextDelete(t *testing.T) {
+	srv, _, chat := newFakeChat(t)
+	chat.RegisterHandleMsg("delete", func(ctx *tgx.Context) error { return ctx.Delete() })
+
+	// The update of a message the server has, so it can be deleted.
+	msgs, err := chat.SendTextMsg(nil, "spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := chat.HandleMsg(msgs[0]); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if srv.Message(fakeChatID, msgs[0].MessageID) != nil {
+		t.Error("expected the message deleted")
+	}
+}
@@ -0,0 +1,184 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	"github.com/0xVanfer/tgx/tgxtest"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Chats of the same bot on the fake server, their IDs go to workers 0, 1 and 0 of 2 workers.
+var dispatchChatIDs = []int64{fakeChatID, otherChatID, -1004444444444}
+
+func newDispatchChats(t *testing.T, handle tgx.Handler) (*tgxtest.Server, *tgx.TgWrapper) {
+	srv := tgxtest.NewServer()
+	t.Cleanup(srv.Close)
+	tg := &tgx.TgWrapper{}
+	tg.SetBotFactory(srv.BotFactory())
+	for i, chatID := range dispatchChatIDs {
+		identifier := fmt.Sprintf("dispatch-%d", i)
+		chat, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, ChatID: chatID, ChatTopic: -1, Identifier: identifier})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tg.UnregisterChat(identifier, false) })
+		chat.RegisterHandleMsg("dispatch", handle)
+	}
+	return srv, tg
+}
+
+var alice = tgbotapi.User{ID: 42, UserName: "alice"}
+
+func TestDispatchOrder(t *testing.T) {
+	const perChat = 20
+	var mu sync.Mutex
+	got := make(map[int64][]int)
+	var wg sync.WaitGroup
+	wg.Add(perChat * len(dispatchChatIDs))
+	srv, tg := newDispatchChats(t, func(ctx *tgx.Context) error {
+		defer wg.Done()
+		n, _ := strconv.Atoi(ctx.Update.Message.Text)
+		// Later updates take less time, they would overtake the earlier ones if run concurrently.
+		time.Sleep(time.Duration(perChat-n) * 100 * time.Microsecond)
+		mu.Lock()
+		defer mu.Unlock()
+		got[ctx.Update.Message.Chat.ID] = append(got[ctx.Update.Message.Chat.ID], n)
+		return nil
+	})
+	tg.SetWorkers(4)
+
+	for n := range perChat {
+		for _, chatID := range dispatchChatIDs {
+			srv.PushMessage(chatID, 0, alice, strconv.Itoa(n))
+		}
+	}
+	tg.Monitor()
+	wg.Wait()
+
+	for _, chatID := range dispatchChatIDs {
+		for i, n := range got[chatID] {
+			if n != i {
+				t.Fatalf("chat %d: expected the updates in order, got %v", chatID, got[chatID])
+			}
+		}
+	}
+}
+
+func TestDispatchWorkers(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(len(dispatchChatIDs))
+	srv, tg := newDispatchChats(t, func(ctx *tgx.Context) error {
+		defer wg.Done()
+		n := running.Add(1)
+		defer running.Add(-1)
+		if n > maxRunning.Load() {
+			maxRunning.Store(n)
+		}
+		<-release
+		return nil
+	})
+	tg.SetWorkers(2)
+
+	for _, chatID := range dispatchChatIDs {
+		srv.PushMessage(chatID, 0, alice, "hello")
+	}
+	tg.Monitor()
+	for deadline := time.Now().Add(5 * time.Second); running.Load() < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	// The third chat waits for the first one on the same worker.
+	time.Sleep(100 * time.Millisecond)
+	if n := running.Load(); n != 2 {
+		t.Errorf("expected 2 updates running, got %d", n)
+	}
+	close(release)
+	wg.Wait()
+	if n := maxRunning.Load(); n != 2 {
+		t.Errorf("expected at most 2 updates running, got %d", n)
+	}
+}
+
+func TestDispatchBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	var handled atomic.Int32
+	srv, tg := newDispatchChats(t, func(ctx *tgx.Context) error {
+		<-release
+		handled.Add(1)
+		return nil
+	})
+	tg.SetWorkers(1)
+	tg.SetQueueSize(1)
+
+	// One running, one queued, and the third waits for room.
+	for range 3 {
+		srv.PushMessage(fakeChatID, 0, alice, "hello")
+	}
+	tg.Monitor()
+	srv.WaitRequests("getUpdates", 1, 5*time.Second)
+	srv.PushMessage(fakeChatID, 0, alice, "later")
+	time.Sleep(200 * time.Millisecond)
+	if n := len(srv.Requests("getUpdates")); n != 1 {
+		t.Errorf("expected getting updates to wait for the queue, got %d getUpdates", n)
+	}
+
+	close(release)
+	srv.WaitRequests("getUpdates", 2, 5*time.Second)
+	for deadline := time.Now().Add(5 * time.Second); handled.Load() < 4 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if n := handled.Load(); n != 4 {
+		t.Errorf("expected 4 updates handled, got %d", n)
+	}
+}
+
+func TestDispatchTimeout(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	record := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, s)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	srv, tg := newDispatchChats(t, func(ctx *tgx.Context) error {
+		defer wg.Done()
+		if ctx.Update.Message.Text == "slow" {
+			// Ignores ctx, keeps running after the timeout.
+			time.Sleep(300 * time.Millisecond)
+		}
+		record(ctx.Update.Message.Text)
+		return nil
+	})
+	timeouts := make(chan error, 2)
+	tg.SetErrorHandler(func(ctx *tgx.Context, handler string, err error) {
+		record("timeout")
+		timeouts <- err
+	})
+	tg.SetHandlerTimeout(50 * time.Millisecond)
+
+	srv.PushMessage(fakeChatID, 0, alice, "slow")
+	srv.PushMessage(fakeChatID, 0, alice, "fast")
+	tg.Monitor()
+	wg.Wait()
+
+	if err := <-timeouts; !errors.Is(err, tgxerrors.ErrHandlerTimeout) {
+		t.Errorf("expected ErrHandlerTimeout, got %v", err)
+	}
+	// The timeout is reported at once, the next update waits for the slow handler.
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(got) != "[timeout slow fast]" {
+		t.Errorf("unexpected order %v", got)
+	}
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	"github.com/0xVanfer/tgx/tgxtest"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Drain the store once it has dead letters, pushed after the error handler returns.
+func waitDeadLetters(store *tgx.MemoryDeadLetterStore) []tgx.DeadLetter {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if letters := store.Drain(); len(letters) > 0 {
+			return letters
+		}
+	}
+	return nil
+}
+
+func TestErrorHandler(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	chat.RegisterHandleCommand("panic", func(ctx *tgx.Context) error {
+		panic("panic on purpose")
+	})
+
+	deadLetters := tgx.NewMemoryDeadLetterStore(100)
+	tg.SetDeadLetterStore(deadLetters)
+	panics := make(chan *tgx.PanicError, 1)
+	tg.SetErrorHandler(func(ctx *tgx.Context, handler string, err error) {
+		var panicErr *tgx.PanicError
+		if errors.As(err, &panicErr) {
+			panics <- panicErr
+		}
+		_, _ = ctx.Reply("Sorry, " + handler + " failed.")
+	})
+	tg.Monitor()
+
+	srv.PushMessage(fakeChatID, 0, tgbotapi.User{ID: 42, UserName: "alice"}, "/panic")
+	sent := srv.WaitRequests("sendMessage", 1, 5*time.Second)
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 reply, got %d", len(sent))
+	}
+	if text := sent[0].Params.Get("text"); text != "Sorry, /panic failed." {
+		t.Errorf("unexpected reply %q", text)
+	}
+
+	panicErr := <-panics
+	if panicErr.Kind != "command" || panicErr.Value != "panic on purpose" {
+		t.Errorf("unexpected panic %+v", panicErr)
+	}
+	// The stack is where the handler panics, not where it is recovered.
+	if !strings.Contains(string(panicErr.Stack), "TestErrorHandler") {
+		t.Errorf("expected the stack of the handler, got\n%s", panicErr.Stack)
+	}
+
+	letters := waitDeadLetters(deadLetters)
+	if len(letters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(letters))
+	}
+	if letters[0].Chat != "fake" || letters[0].Handler != "/panic" || letters[0].Update.Message.Text != "/panic" {
+		t.Errorf("unexpected dead letter %+v", letters[0])
+	}
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	var calls atomic.Int32
+	chat.RegisterHandleMsg("flaky", func(ctx *tgx.Context) error {
+		if calls.Add(1) == 1 {
+			return errors.New("flaky")
+		}
+		_, err := ctx.Reply("done")
+		return err
+	})
+	deadLetters := tgx.NewMemoryDeadLetterStore(100)
+	tg.SetDeadLetterStore(deadLetters)
+	// A panicking error handler does not stop monitoring.
+	tg.SetErrorHandler(func(ctx *tgx.Context, handler string, err error) {
+		panic("error handler panic")
+	})
+	tg.Monitor()
+
+	srv.PushMessage(fakeChatID, 0, tgbotapi.User{ID: 42, UserName: "alice"}, "hello")
+	letters := waitDeadLetters(deadLetters)
+	if len(letters) != 1 || letters[0].Handler != "flaky" || letters[0].Error != "flaky" {
+		t.Fatalf("unexpected dead letters %+v", letters)
+	}
+
+	if err := tg.Replay(letters[0]); err != nil {
+		t.Fatal(err)
+	}
+	if sent := srv.Requests("sendMessage"); len(sent) != 1 || sent[0].Params.Get("text") != "done" {
+		t.Errorf("expected the replay replying, got %+v", sent)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected the handler run twice, got %d", n)
+	}
+}
+
+func TestReplayHelp(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	chat.RegisterHandleCommand("ping", func(ctx *tgx.Context) error { return nil })
+	deadLetters := tgx.NewMemoryDeadLetterStore(100)
+	tg.SetDeadLetterStore(deadLetters)
+	tg.Monitor()
+
+	srv.Fail("sendMessage", tgxtest.BadRequest("chat not found"))
+	srv.PushMessage(fakeChatID, 0, tgbotapi.User{ID: 42, UserName: "alice"}, "/help")
+	letters := waitDeadLetters(deadLetters)
+	if len(letters) != 1 || letters[0].Handler != "/help" {
+		t.Fatalf("unexpected dead letters %+v", letters)
+	}
+
+	// The built-in /help is replied again.
+	if err := tg.Replay(letters[0]); err != nil {
+		t.Fatal(err)
+	}
+	sent := srv.Requests("sendMessage")
+	if len(sent) != 2 || !strings.HasPrefix(sent[1].Params.Get("text"), "Available commands:") {
+		t.Errorf("expected the help replied, got %+v", sent)
+	}
+
+	tg.SetDisableHelp(true)
+	if err := tg.Replay(letters[0]); !errors.Is(err, tgxerrors.ErrHandlerNotFound) {
+		t.Errorf("expected ErrHandlerNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,397 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	"github.com/0xVanfer/tgx/tgxtest"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const fakeChatID = -1002222222222
+
+// A wrapper with a chat talking to a new fake server.
+func newFakeChat(t *testing.T) (*tgxtest.Server, *tgx.TgWrapper, *tgx.Chat) {
+	srv := tgxtest.NewServer()
+	t.Cleanup(srv.Close)
+
+	tg := &tgx.TgWrapper{}
+	tg.SetBotFactory(srv.BotFactory())
+	chat, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, ChatID: fakeChatID, ChatTopic: -1, Identifier: "fake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat.SetRetryInterval(time.Millisecond)
+	t.Cleanup(func() { _ = tg.UnregisterChat("fake", false) })
+	return srv, tg, chat
+}
+
+func TestFakeMonitor(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	chat.RegisterHandleCommand("ping", func(ctx *tgx.Context) (err error) {
+		_, err = ctx.Reply("pong " + ctx.Args().Raw())
+		return
+	})
+	tg.Monitor()
+
+	srv.PushMessage(fakeChatID, 7, tgbotapi.User{ID: 42, UserName: "alice"}, "/ping hello")
+	sent := srv.WaitRequests("sendMessage", 1, 5*time.Second)
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 reply, got %d", len(sent))
+	}
+	if text := sent[0].Params.Get("text"); text != "pong hello" {
+		t.Errorf("unexpected reply %q", text)
+	}
+	if topic := sent[0].Params.Get("reply_to_message_id"); topic != "7" {
+		t.Errorf("expected the reply in topic 7, got %q", topic)
+	}
+}
+
+func TestFakeRetry(t *testing.T) {
+	srv, _, chat := newFakeChat(t)
+
+	// Temporary errors and flood waits are retried.
+	srv.Fail("sendMessage", tgxtest.ServerError(), tgxtest.FloodWait(1))
+	start := time.Now()
+	if _, err := chat.SendTextMsg(nil, "retried"); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.Requests("sendMessage")) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(srv.Requests("sendMessage")))
+	}
+	if time.Since(start) < time.Second {
+		t.Error("expected to wait for retry_after")
+	}
+
+	// Permanent errors fail at once.
+	srv.Fail("sendMessage", tgxtest.BadRequest("chat not found"))
+	_, err := chat.SendTextMsg(nil, "failed")
+	if !errors.Is(err, tgxerrors.ErrPermanent) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+	if len(srv.Requests("sendMessage")) != 4 {
+		t.Errorf("expected no retry, got %d attempts", len(srv.Requests("sendMessage"))-3)
+	}
+}
+
+func TestFakeGatewayError(t *testing.T) {
+	srv, _, chat := newFakeChat(t)
+
+	// The HTML error page of a proxy can not be decoded, but is retried like other server errors.
+	srv.Fail("sendMessage", tgxtest.GatewayError(), tgxtest.GatewayError())
+	if _, err := chat.SendTextMsg(nil, "retried"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.Requests("sendMessage")); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestFakeContextDeadline(t *testing.T) {
+	srv, _, chat := newFakeChat(t)
+	release := srv.Hold("sendMessage")
+	defer release()
+
+	// The deadline ends the HTTP request held by the server, not only the retries and waits.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		_, err := chat.SendTextMsgContext(ctx, nil, "held")
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the deadline exceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the request to stop before released")
+	}
+	if n := len(srv.Requests("sendMessage")); n != 1 {
+		t.Errorf("expected no retry after the deadline, got %d attempts", n)
+	}
+}
+
+func TestFakeSplitText(t *testing.T) {
+	srv, _, chat := newFakeChat(t)
+
+	text := strings.Repeat("abcd", 2048) + "This start at 8192."
+	msgs, err := chat.SendTextMsg(nil, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 || len(srv.Messages(fakeChatID)) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(msgs))
+	}
+	if srv.Messages(fakeChatID)[2].Text != "This start at 8192." {
+		t.Errorf("unexpected last message %q", srv.Messages(fakeChatID)[2].Text)
+	}
+}
+
+func TestFakeManagedMsgs(t *testing.T) {
+	srv, _, chat := newFakeChat(t)
+
+	msgs, err := chat.SendTextMsg(nil, "to be edited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = chat.RegisterMsgs(msgs, "managed", ""); err != nil {
+		t.Fatal(err)
+	}
+	managed, err := chat.GetMsg("managed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = managed.EditText("edited"); err != nil {
+		t.Fatal(err)
+	}
+	if text := srv.Message(fakeChatID, msgs[0].MessageID).Text; text != "edited" {
+		t.Errorf("expected the message edited, got %q", text)
+	}
+
+	if err = chat.DeleteMsgs("managed"); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.Messages(fakeChatID)) != 0 {
+		t.Error("expected the message deleted")
+	}
+	if _, err = chat.GetMsg("managed"); !errors.Is(err, tgxerrors.ErrIdentifierNotFound) {
+		t.Errorf("expected the identifier freed, got %v", err)
+	}
+}
+
+func TestFakeEndpoint(t *testing.T) {
+	srv := tgxtest.NewServer()
+	defer srv.Close()
+
+	// Talk to the server as a self-hosted Bot API server.
+	tg := &tgx.TgWrapper{}
+	tg.SetAPIEndpoint(srv.URL)
+	tg.SetHTTPClient(srv.Client())
+	chat, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: "654321:endpoint", ChatID: fakeChatID, Identifier: "endpoint"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := chat.SendPhoto(nil, "../internal/assets/favicon.png", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := chat.GetFileURL(msg.Photo[0].FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(url, srv.URL+"/file/bot654321:endpoint/") {
+		t.Errorf("expected the file URL on the server, got %s", url)
+	}
+	resp, err := srv.Client().Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, _ := io.ReadAll(resp.Body)
+	local, _ := os.ReadFile("../internal/assets/favicon.png")
+	if !bytes.Equal(content, local) {
+		t.Error("expected the uploaded file downloaded")
+	}
+}
+
+func TestFakeSharedBot(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+
+	other, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, ChatID: fakeChatID, ChatTopic: 3, Identifier: "fake-other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterChat("fake-other", false)
+
+	if other.Bot != chat.Bot {
+		t.Error("expected the chats sharing the bot")
+	}
+	if n := len(srv.Requests("getMe")); n != 1 {
+		t.Errorf("expected the token validated once, got %d getMe", n)
+	}
+
+	meta, err := tg.GetBotMeta(srv.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != srv.BotUser.ID || meta.UserName != srv.BotUser.UserName {
+		t.Errorf("unexpected bot %+v", meta)
+	}
+	if len(meta.Chats) != 2 || meta.Chats[0] != "fake" || meta.Chats[1] != "fake-other" {
+		t.Errorf("unexpected chats %v", meta.Chats)
+	}
+	if _, err = tg.GetBotMeta("000:unknown"); !errors.Is(err, tgxerrors.ErrBotNotFound) {
+		t.Errorf("expected ErrBotNotFound, got %v", err)
+	}
+}
+
+func TestFakeRegisterCollision(t *testing.T) {
+	srv := tgxtest.NewServer()
+	defer srv.Close()
+	tg := &tgx.TgWrapper{}
+	tg.SetBotFactory(srv.BotFactory())
+
+	// Registering the same identifier at once, with bots of their own and a shared one.
+	tokens := make([]string, 10)
+	chats := make([]*tgx.Chat, len(tokens))
+	var wg sync.WaitGroup
+	for i := range tokens {
+		tokens[i] = srv.Token
+		if i%2 == 1 {
+			tokens[i] = fmt.Sprintf("%d:collision", i)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			chat, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: tokens[i], ChatID: fakeChatID, Identifier: "collision"})
+			if err != nil && !errors.Is(err, tgxerrors.ErrIdentifierAlreadyExists) {
+				t.Error(err)
+			}
+			chats[i] = chat
+		}(i)
+	}
+	wg.Wait()
+
+	var registered *tgx.Chat
+	for _, chat := range chats {
+		if chat == nil {
+			continue
+		}
+		if registered != nil {
+			t.Fatal("expected one chat registered")
+		}
+		registered = chat
+	}
+	if registered == nil {
+		t.Fatal("expected one chat registered")
+	}
+	// Only the bot of the registered chat is kept.
+	for _, token := range tokens {
+		_, err := tg.GetBotHealth(token)
+		if kept := err == nil; kept != (token == registered.Bot.Token()) {
+			t.Errorf("bot %s kept: %v", token, kept)
+		}
+	}
+
+	if err := tg.UnregisterChat("collision", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tg.GetBotHealth(registered.Bot.Token()); !errors.Is(err, tgxerrors.ErrBotNotFound) {
+		t.Errorf("expected ErrBotNotFound, got %v", err)
+	}
+}
+
+func TestFakeLazyRegistration(t *testing.T) {
+	srv := tgxtest.NewServer()
+	defer srv.Close()
+	// Telegram is unreachable when registering, or a proxy in front of it fails.
+	srv.Fail("getMe", tgxtest.GatewayError(), tgxtest.BadRequest("not ready"))
+
+	tg := &tgx.TgWrapper{}
+	tg.SetLazyRegistration(true)
+	tg.SetBotFactory(srv.BotFactory())
+	if _, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: "invalid", ChatID: fakeChatID, Identifier: "invalid"}); !errors.Is(err, tgxerrors.ErrInvalidBotToken) {
+		t.Errorf("expected ErrInvalidBotToken, got %v", err)
+	}
+	chat, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, ChatID: fakeChatID, Identifier: "lazy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterChat("lazy", false)
+	chat.SetRetry(1)
+
+	if health, _ := tg.GetBotHealth(srv.Token); health.Status != tgx.BotConnecting {
+		t.Errorf("expected connecting, got %s", health.Status)
+	}
+	if _, err = chat.SendTextMsg(nil, "too early"); !errors.Is(err, tgxerrors.ErrBotNotReady) {
+		t.Errorf("expected ErrBotNotReady, got %v", err)
+	}
+
+	// Sends with a context wait for the connection.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err = chat.SendTextMsgContext(ctx, nil, "connected"); err != nil {
+		t.Fatal(err)
+	}
+	health, err := tg.GetBotHealth(srv.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != tgx.BotConnected || health.LastError != nil {
+		t.Errorf("expected connected, got %+v", health)
+	}
+	if meta, err := tg.GetBotMeta(srv.Token); err != nil || meta.UserName != srv.BotUser.UserName {
+		t.Errorf("unexpected bot %+v, %v", meta, err)
+	}
+}
+
+func TestFakeLazyRejected(t *testing.T) {
+	srv := tgxtest.NewServer()
+	defer srv.Close()
+	srv.Fail("getMe", tgxtest.Unauthorized())
+
+	tg, err := tgx.InitLazy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg.SetBotFactory(srv.BotFactory())
+	chat, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, ChatID: fakeChatID, Identifier: "rejected"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterChat("rejected", false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err = chat.SendTextMsgContext(ctx, nil, "never sent"); !errors.Is(err, tgxerrors.ErrPermanent) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+	if health, _ := tg.GetBotHealth(srv.Token); health.Status != tgx.BotFailed || health.LastError == nil {
+		t.Errorf("expected failed, got %+v", health)
+	}
+}
+
+func TestFakeLazyHealthRedacted(t *testing.T) {
+	const token = "123456:SECRETsecret"
+	tg, err := tgx.InitLazy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing listens on port 1, the errors have the request URL with the token.
+	chat, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: token, ChatID: fakeChatID, Identifier: "unreachable", APIEndpoint: "http://127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterChat("unreachable", false)
+
+	var health tgx.BotHealth
+	deadline := time.Now().Add(5 * time.Second)
+	for health, _ = tg.GetBotHealth(token); health.LastError == nil && time.Now().Before(deadline); health, _ = tg.GetBotHealth(token) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if health.LastError == nil {
+		t.Fatal("expected the connection error")
+	}
+	if msg := health.LastError.Error(); strings.Contains(msg, "SECRETsecret") || !strings.Contains(msg, "<bot_token>") {
+		t.Errorf("expected the token redacted, got %q", msg)
+	}
+	if !errors.Is(health.LastError, tgxerrors.ErrRetryable) {
+		t.Errorf("expected the class kept, got %v", health.LastError)
+	}
+	if chat.Bot.Token() != token {
+		t.Error("expected the token of the bot kept")
+	}
+}
@@ -0,0 +1,134 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/0xVanfer/tgx"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestFilters(t *testing.T) {
+	chat := &tgx.Chat{ChatID: fakeChatID, ChatTopic: 7}
+	msg := &tgbotapi.Message{
+		MessageID: 21,
+		From:      &tgbotapi.User{ID: 42, UserName: "alice", LanguageCode: "en-US"},
+		Chat:      &tgbotapi.Chat{ID: fakeChatID, Type: "supergroup"},
+		Text:      "hello @bob",
+		Entities:  []tgbotapi.MessageEntity{{Type: "mention", Offset: 6, Length: 4}},
+	}
+	caption := &tgbotapi.Message{MessageID: 22, Chat: msg.Chat, Caption: "a photo", Photo: []tgbotapi.PhotoSize{{FileID: "photo"}}}
+	inTopic := &tgbotapi.Message{MessageID: 23, Chat: msg.Chat, ReplyToMessage: &tgbotapi.Message{MessageID: 7}}
+	reply := &tgbotapi.Message{MessageID: 24, Chat: msg.Chat, ReplyToMessage: &tgbotapi.Message{MessageID: 21}}
+	always := func(ctx *tgx.Context) bool { return true }
+	never := func(ctx *tgx.Context) bool { return false }
+
+	tests := []struct {
+		name   string
+		filter tgx.Filter
+		msg    *tgbotapi.Message
+		want   bool
+	}{
+		{"and", tgx.And(always, always), msg, true},
+		{"and one not matching", tgx.And(always, never), msg, false},
+		{"empty and", tgx.And(), msg, true},
+		{"or", tgx.Or(never, always), msg, true},
+		{"or none matching", tgx.Or(never, never), msg, false},
+		{"empty or", tgx.Or(), msg, false},
+		{"not", tgx.Not(never), msg, true},
+		{"prefix", tgx.Prefix("hello"), msg, true},
+		{"prefix not matching", tgx.Prefix("bob"), msg, false},
+		{"prefix of caption", tgx.Prefix("a photo"), caption, true},
+		{"contains", tgx.Contains("@bob"), msg, true},
+		{"contains not matching", tgx.Contains("alice"), msg, false},
+		{"from user", tgx.FromUser(1, 42), msg, true},
+		{"from other user", tgx.FromUser(1), msg, false},
+		{"in chat", tgx.InChat(fakeChatID), msg, true},
+		{"in other chat", tgx.InChat(otherChatID), msg, false},
+		{"has photo", tgx.HasPhoto(), caption, true},
+		{"has no photo", tgx.HasPhoto(), msg, false},
+		{"is reply", tgx.IsReply(), reply, true},
+		{"reply to the topic", tgx.IsReply(), inTopic, false},
+		{"not a reply", tgx.IsReply(), msg, false},
+		{"has entity", tgx.HasEntity("url", "mention"), msg, true},
+		{"has no entity", tgx.HasEntity("hashtag"), msg, false},
+		{"language", tgx.Language("en"), msg, true},
+		{"full language", tgx.Language("EN-us"), msg, true},
+		{"other language", tgx.Language("ru"), msg, false},
+		{"no language", tgx.Language("en"), caption, false},
+		// Filters about messages never match updates without a message.
+		{"no message", tgx.Or(tgx.Prefix(""), tgx.Contains(""), tgx.IsReply(), tgx.Language("en")), nil, false},
+	}
+	for _, test := range tests {
+		ctx := &tgx.Context{Update: &tgx.Update{Update: tgbotapi.Update{Message: test.msg}}, Chat: chat}
+		if got := test.filter(ctx); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestRegexFilter(t *testing.T) {
+	_, _, chat := newFakeChat(t)
+	var got []string
+	chat.RegisterHandleMsg("send", func(ctx *tgx.Context) error {
+		got = append(got, fmt.Sprint(ctx.Matches()), ctx.NamedMatch("amount"), ctx.NamedMatch("unknown"))
+		return nil
+	}, tgx.WithFilter(tgx.Regex(`^send (?P<amount>\d+) to (\w+)$`)))
+
+	for _, text := range []string{"send 5 to bob", "send five to bob"} {
+		if errs := chat.HandleMsg(&tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: fakeChatID}, Text: text}); len(errs) != 0 {
+			t.Fatal(errs)
+		}
+	}
+	if fmt.Sprint(got) != "[[send 5 to bob 5 bob] 5 ]" {
+		t.Errorf("unexpected matches %q", got)
+	}
+}
+
+func TestFilterSkipsMiddlewares(t *testing.T) {
+	_, _, chat := newFakeChat(t)
+	var got []string
+	chat.Use(recordMiddleware("chat", &got))
+	chat.RegisterHandleMsg("hello", func(ctx *tgx.Context) error {
+		got = append(got, "handler")
+		return nil
+	}, tgx.WithFilter(tgx.Prefix("hello")), tgx.WithFilter(tgx.FromUser(42)))
+
+	for _, msg := range []*tgbotapi.Message{
+		{MessageID: 1, Chat: &tgbotapi.Chat{ID: fakeChatID}, Text: "bye"},
+		{MessageID: 2, Chat: &tgbotapi.Chat{ID: fakeChatID}, From: &tgbotapi.User{ID: 43}, Text: "hello"},
+		{MessageID: 3, Chat: &tgbotapi.Chat{ID: fakeChatID}, From: &tgbotapi.User{ID: 42}, Text: "hello"},
+	} {
+		if errs := chat.HandleMsg(msg); len(errs) != 0 {
+			t.Fatal(errs)
+		}
+	}
+	// Only the message matching both filters.
+	if fmt.Sprint(got) != "[chat handler /chat]" {
+		t.Errorf("unexpected calls %v", got)
+	}
+}
+
+func TestFilterPanic(t *testing.T) {
+	_, _, chat := newFakeChat(t)
+	var got []string
+	chat.RegisterHandleMsg("forwarded", func(ctx *tgx.Context) error {
+		got = append(got, "forwarded")
+		return nil
+	}, tgx.WithFilter(func(ctx *tgx.Context) bool { return ctx.Msg().ForwardFrom.ID == 42 }))
+	chat.RegisterHandleMsg("next", func(ctx *tgx.Context) error {
+		got = append(got, "next")
+		return nil
+	})
+
+	// The panic of the filter is returned like the panics of handlers, and the other handlers still run.
+	errs := chat.HandleMsg(&tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: fakeChatID}, Text: "not forwarded"})
+	var panicErr *tgx.PanicError
+	if !errors.As(errs["forwarded"], &panicErr) || panicErr.Kind != "filter" || panicErr.Handler != "forwarded" {
+		t.Errorf("expected the filter panic, got %v", errs)
+	}
+	if fmt.Sprint(got) != "[next]" {
+		t.Errorf("unexpected calls %v", got)
+	}
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var helloMsg = &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: fakeChatID, Type: "supergroup"}, Text: "hello"}
+
+func TestHandlerPriority(t *testing.T) {
+	_, _, chat := newFakeChat(t)
+	var got []string
+	record := func(name string) tgx.Handler {
+		return func(ctx *tgx.Context) error {
+			got = append(got, name)
+			return nil
+		}
+	}
+	chat.RegisterHandleMsg("a", record("a"))
+	chat.RegisterHandleMsg("b", record("b"), tgx.WithPriority(10))
+	chat.RegisterHandleMsg("c", record("c"))
+	chat.RegisterHandleMsg("d", record("d"), tgx.WithPriority(10))
+	chat.RegisterHandleMsg("e", record("e"), tgx.WithPriority(-1))
+	// Replacing a handler keeps its registration order.
+	chat.RegisterHandleMsg("a", record("a2"))
+
+	chat.HandleMsg(helloMsg)
+	if fmt.Sprint(got) != "[b d a2 c e]" {
+		t.Errorf("unexpected order %v", got)
+	}
+}
+
+func TestStopPropagation(t *testing.T) {
+	_, _, chat := newFakeChat(t)
+	var got []string
+	chat.RegisterHandleMsg("first", func(ctx *tgx.Context) error {
+		got = append(got, "first")
+		ctx.StopPropagation()
+		if !ctx.IsPropagationStopped() {
+			t.Error("expected the propagation stopped")
+		}
+		return nil
+	})
+	chat.RegisterHandleMsg("second", func(ctx *tgx.Context) error {
+		got = append(got, "second")
+		return nil
+	})
+
+	chat.HandleMsg(helloMsg)
+	if fmt.Sprint(got) != "[first]" {
+		t.Errorf("expected the second handler not run, got %v", got)
+	}
+	// Each update has its own state.
+	got = nil
+	chat.HandleMsg(helloMsg)
+	if fmt.Sprint(got) != "[first]" {
+		t.Errorf("expected the first handler run again, got %v", got)
+	}
+
+	// Handlers running concurrently can not be stopped.
+	var mu sync.Mutex
+	got = nil
+	chat.SetHandleConcurrently(true)
+	chat.RegisterHandleMsg("second", func(ctx *tgx.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, "second")
+		return nil
+	})
+	chat.RegisterHandleMsg("first", func(ctx *tgx.Context) error {
+		ctx.StopPropagation()
+		return nil
+	})
+	chat.HandleMsg(helloMsg)
+	if fmt.Sprint(got) != "[second]" {
+		t.Errorf("expected the second handler run, got %v", got)
+	}
+}
+
+func TestStopPropagationAcrossChats(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	everywhere, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, Identifier: "fake-everywhere"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterChat("fake-everywhere", false)
+
+	handled := make(chan string, 10)
+	chat.RegisterHandleMsg("stop", func(ctx *tgx.Context) error {
+		handled <- ctx.Update.Message.Text
+		if ctx.Update.Message.Text == "stop" {
+			ctx.StopPropagation()
+		}
+		return nil
+	})
+	everywhere.RegisterHandleMsg("everywhere", func(ctx *tgx.Context) error {
+		handled <- "everywhere " + ctx.Update.Message.Text
+		return nil
+	})
+	tg.Monitor()
+
+	// The chats are checked in the order they are registered.
+	srv.PushMessage(fakeChatID, 0, alice, "stop")
+	srv.PushMessage(fakeChatID, 0, alice, "go")
+	var got []string
+	for len(got) < 3 {
+		select {
+		case text := <-handled:
+			got = append(got, text)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected 3 handled, got %v", got)
+		}
+	}
+	if fmt.Sprint(got) != "[stop go everywhere go]" {
+		t.Errorf("unexpected handled %v", got)
+	}
+}
@@ -0,0 +1,222 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxtest"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const otherChatID = -1003333333333
+
+func TestHelpErrorChat(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	other, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, ChatID: otherChatID, ChatTopic: -1, Identifier: "fake-other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterChat("fake-other", false)
+	other.SetRetry(1)
+
+	reported := make(chan string, 2)
+	for _, c := range []*tgx.Chat{chat, other} {
+		c.SetErrorHandler(func(ctx *tgx.Context, handler string, err error) {
+			reported <- c.Identifier
+		})
+	}
+	other.RegisterHandleCommand("ping", func(ctx *tgx.Context) error { return nil })
+	tg.Monitor()
+
+	// The reply of /help in the other chat fails.
+	srv.Fail("sendMessage", tgxtest.BadRequest("chat not found"))
+	srv.PushMessage(otherChatID, 0, tgbotapi.User{ID: 42, UserName: "alice"}, "/help")
+	select {
+	case identifier := <-reported:
+		if identifier != "fake-other" {
+			t.Errorf("expected the error reported to fake-other, got %s", identifier)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the error reported")
+	}
+}
+
+// Register the commands of the help tests: "ping" everywhere, "send" and a hidden one in the fake chat.
+func registerHelpCommands(t *testing.T, srv *tgxtest.Server, tg *tgx.TgWrapper, chat *tgx.Chat) {
+	everywhere, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, Identifier: "fake-everywhere"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tg.UnregisterChat("fake-everywhere", false) })
+	noop := func(ctx *tgx.Context) error { return nil }
+	everywhere.RegisterCommand(tgx.Command{Name: "ping", Description: "Ping", Descriptions: map[string]string{"ru": "Пинг"}, Handle: noop})
+	chat.RegisterCommand(tgx.Command{
+		Name:        "send",
+		Aliases:     []string{"s"},
+		Args:        []tgx.CommandArg{{Name: "amount", Type: tgx.ArgInt}},
+		Description: "Send",
+		Handle:      noop,
+	})
+	chat.RegisterCommand(tgx.Command{Name: "secret", Hidden: true, Handle: noop})
+}
+
+func TestHelp(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	registerHelpCommands(t, srv, tg, chat)
+	tg.Monitor()
+
+	tests := []struct {
+		chatID       int64
+		languageCode string
+		want         string
+	}{
+		{fakeChatID, "", "Available commands:\n/ping - Ping\n/send <amount:int> - Send (aliases: /s)"},
+		{fakeChatID, "ru-RU", "Available commands:\n/ping - Пинг\n/send <amount:int> - Send (aliases: /s)"},
+		// Only the commands of the chats matching the update.
+		{otherChatID, "", "Available commands:\n/ping - Ping"},
+	}
+	for i, test := range tests {
+		srv.PushMessage(test.chatID, 0, tgbotapi.User{ID: 42, UserName: "alice", LanguageCode: test.languageCode}, "/help")
+		sent := srv.WaitRequests("sendMessage", i+1, 5*time.Second)
+		if len(sent) != i+1 {
+			t.Fatalf("expected /help replied")
+		}
+		if text := sent[i].Params.Get("text"); text != test.want {
+			t.Errorf("expected %q, got %q", test.want, text)
+		}
+	}
+
+	// A chat registering its own help replaces the built-in one.
+	chat.RegisterHandleCommand("help", func(ctx *tgx.Context) error {
+		_, err := ctx.Reply("my help")
+		return err
+	})
+	srv.PushMessage(fakeChatID, 0, tgbotapi.User{ID: 42, UserName: "alice"}, "/help")
+	sent := srv.WaitRequests("sendMessage", len(tests)+1, 5*time.Second)
+	if text := sent[len(sent)-1].Params.Get("text"); text != "my help" {
+		t.Errorf("expected the help of the chat, got %q", text)
+	}
+}
+
+func TestHelpDisabled(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	registerHelpCommands(t, srv, tg, chat)
+	tg.SetDisableHelp(true)
+	tg.Monitor()
+
+	srv.PushMessage(fakeChatID, 0, tgbotapi.User{ID: 42, UserName: "alice"}, "/help")
+	srv.PushMessage(fakeChatID, 0, tgbotapi.User{ID: 42, UserName: "alice"}, "/send x")
+	// The usage of /send is the only reply.
+	sent := srv.WaitRequests("sendMessage", 1, 5*time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if sent = srv.Requests("sendMessage"); len(sent) != 1 || !strings.HasPrefix(sent[0].Params.Get("text"), "invalid argument") {
+		t.Errorf("expected no /help reply, got %+v", sent)
+	}
+
+	if err := tg.SyncCommands(); err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range srv.Requests("setMyCommands") {
+		if strings.Contains(request.Params.Get("commands"), `"help"`) {
+			t.Errorf("expected no help command, got %s", request.Params.Get("commands"))
+		}
+	}
+}
+
+// The menu a setMyCommands or deleteMyCommands request is for, e.g. "default ru", or "<chat ID> " for the default language.
+func commandMenu(t *testing.T, request tgxtest.Request) string {
+	var scope struct {
+		Type   string `json:"type"`
+		ChatID int64  `json:"chat_id"`
+	}
+	if err := json.Unmarshal([]byte(request.Params.Get("scope")), &scope); err != nil {
+		t.Fatal(err)
+	}
+	if scope.ChatID != 0 {
+		scope.Type = fmt.Sprint(scope.ChatID)
+	}
+	return scope.Type + " " + request.Params.Get("language_code")
+}
+
+func TestSyncCommands(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	registerHelpCommands(t, srv, tg, chat)
+	// Telegram rejects the whole menu for an invalid name.
+	chat.RegisterCommand(tgx.Command{Name: "bad-name", Description: "Invalid", Handle: func(ctx *tgx.Context) error { return nil }})
+	if err := tg.SyncCommands(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string][]string)
+	for _, request := range srv.Requests("setMyCommands") {
+		var commands []tgbotapi.BotCommand
+		if err := json.Unmarshal([]byte(request.Params.Get("commands")), &commands); err != nil {
+			t.Fatal(err)
+		}
+		key := commandMenu(t, request)
+		for _, command := range commands {
+			got[key] = append(got[key], command.Command+" "+command.Description)
+		}
+	}
+	want := map[string][]string{
+		"default ":                     {"ping Ping", "help Show available commands"},
+		"default ru":                   {"ping Пинг", "help Show available commands"},
+		fmt.Sprint(fakeChatID) + " ":   {"ping Ping", "send Send", "help Show available commands"},
+		fmt.Sprint(fakeChatID) + " ru": {"ping Пинг", "send Send", "help Show available commands"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected menus\n%v\ngot\n%v", want, got)
+	}
+
+	// The menus deleted by the requests since the first ones.
+	deletedMenus := func(from int) (menus []string) {
+		for _, request := range srv.Requests("deleteMyCommands")[from:] {
+			menus = append(menus, commandMenu(t, request))
+		}
+		slices.Sort(menus)
+		return
+	}
+	if menus := deletedMenus(0); len(menus) != 0 {
+		t.Errorf("expected no menu deleted, got %q", menus)
+	}
+
+	// The menus of a language no longer used are deleted.
+	everywhere, err := tg.GetChat("fake-everywhere")
+	if err != nil {
+		t.Fatal(err)
+	}
+	everywhere.RegisterCommand(tgx.Command{Name: "ping", Description: "Ping", Handle: func(ctx *tgx.Context) error { return nil }})
+	if err = tg.SyncCommands(); err != nil {
+		t.Fatal(err)
+	}
+	if menus := deletedMenus(0); fmt.Sprint(menus) != fmt.Sprintf("[%d ru default ru]", fakeChatID) {
+		t.Errorf("expected the ru menus deleted, got %q", menus)
+	}
+
+	// A chat left without commands gets its menu deleted, and so does the default menu without chats with ChatID 0.
+	chat.UnregisterHandleCommand("send")
+	chat.UnregisterHandleCommand("secret")
+	chat.UnregisterHandleCommand("bad-name")
+	if err = tg.UnregisterChat("fake-everywhere", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = tg.SyncCommands(); err != nil {
+		t.Fatal(err)
+	}
+	if menus := deletedMenus(2); fmt.Sprint(menus) != fmt.Sprintf("[%d  default ]", fakeChatID) {
+		t.Errorf("expected the menus of the chat and the default one deleted, got %q", menus)
+	}
+
+	// Only the menu of the chat without commands is deleted again.
+	if err = tg.SyncCommands(); err != nil {
+		t.Fatal(err)
+	}
+	if menus := deletedMenus(4); fmt.Sprint(menus) != fmt.Sprintf("[%d ]", fakeChatID) {
+		t.Errorf("expected only the menu of the chat deleted again, got %q", menus)
+	}
+}
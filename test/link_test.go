@@ -0,0 +1,95 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestParseMsgLink(t *testing.T) {
+	valid := map[string]tgx.MsgLink{
+		"https://t.me/c/123456789/2/21":         {ChatAndTopic: tgx.ChatAndTopic{ChatID: -100123456789, ChatTopic: 2}, MessageID: 21},
+		"https://t.me/c/123456789/21":           {ChatAndTopic: tgx.ChatAndTopic{ChatID: -100123456789, ChatTopic: -1}, MessageID: 21},
+		"t.me/c/123456789/21?thread=2":          {ChatAndTopic: tgx.ChatAndTopic{ChatID: -100123456789, ChatTopic: 2}, MessageID: 21},
+		"https://t.me/tgx_news/21":              {ChatAndTopic: tgx.ChatAndTopic{ChatTopic: -1}, Username: "tgx_news", MessageID: 21},
+		"http://telegram.me/tgx_news/2/21/":     {ChatAndTopic: tgx.ChatAndTopic{ChatTopic: 2}, Username: "tgx_news", MessageID: 21},
+		" https://www.t.me/tgx_news/21?single ": {ChatAndTopic: tgx.ChatAndTopic{ChatTopic: -1}, Username: "tgx_news", MessageID: 21},
+	}
+	for link, want := range valid {
+		got, err := tgx.ParseMsgLink(link)
+		if err != nil {
+			t.Errorf("%s: %v", link, err)
+			continue
+		}
+		if *got != want {
+			t.Errorf("%s: expected %+v, got %+v", link, want, *got)
+		}
+	}
+
+	for _, link := range []string{
+		"https://example.com/c/123456789/21",
+		"https://t.me/c/abc/21",
+		"https://t.me/c/123456789",
+		"https://t.me/c/123456789/1/2/3",
+		"https://t.me/tgx_news",
+		"https://t.me/tgx_news/0",
+		"https://t.me/x/21",
+		"ftp://t.me/tgx_news/21",
+	} {
+		if _, err := tgx.ParseMsgLink(link); !errors.Is(err, tgxerrors.ErrInvalidMsgLink) {
+			t.Errorf("%s: expected ErrInvalidMsgLink, got %v", link, err)
+		}
+	}
+}
+
+func TestChatMsgLink(t *testing.T) {
+	topicChat := &tgx.Chat{ChatID: -100123456789, ChatTopic: 2}
+	msgs := map[string]*tgx.ChatMsg{
+		"https://t.me/c/123456789/2/21": {Chat: topicChat, Msg: &tgbotapi.Message{MessageID: 21, Chat: &tgbotapi.Chat{ID: -100123456789, Type: "supergroup"}, ReplyToMessage: &tgbotapi.Message{MessageID: 2}}},
+		"https://t.me/c/123456789/22":   {Msg: &tgbotapi.Message{MessageID: 22, Chat: &tgbotapi.Chat{ID: -100123456789, Type: "supergroup"}, ReplyToMessage: &tgbotapi.Message{MessageID: 2}}},
+		"https://t.me/c/123456789/21":   {Msg: &tgbotapi.Message{MessageID: 21, Chat: &tgbotapi.Chat{ID: -100123456789, Type: "channel"}}},
+		"https://t.me/tgx_news/21":      {Msg: &tgbotapi.Message{MessageID: 21, Chat: &tgbotapi.Chat{ID: -100123456789, Type: "channel", UserName: "tgx_news"}}},
+	}
+	for want, msg := range msgs {
+		link, err := msg.Link()
+		if err != nil || link != want {
+			t.Errorf("expected %s, got %s, %v", want, link, err)
+		}
+		// Links round trip.
+		parsed, _ := tgx.ParseMsgLink(link)
+		if parsed.String() != want {
+			t.Errorf("expected %s, got %s", want, parsed)
+		}
+	}
+
+	for _, chat := range []*tgbotapi.Chat{{ID: 42, Type: "private"}, {ID: -4242, Type: "group"}} {
+		if _, err := (&tgx.ChatMsg{Msg: &tgbotapi.Message{MessageID: 21, Chat: chat}}).Link(); !errors.Is(err, tgxerrors.ErrNoMsgLink) {
+			t.Errorf("expected ErrNoMsgLink for %s chats, got %v", chat.Type, err)
+		}
+	}
+}
+
+func TestConfigLink(t *testing.T) {
+	conf := &tgx.Config{Chats: []tgx.SingleChatConf{
+		{BotToken: "123:abc", Identifier: "linked", Link: "https://t.me/c/123456789/2/21"},
+		{BotToken: "123:abc", Identifier: "public", Link: "https://t.me/tgx_news/21"},
+		{BotToken: "123:abc", Identifier: "both", Link: "https://t.me/c/123456789/21", ChatID: -100123456789},
+	}}
+	err := conf.Validate()
+	if !errors.Is(err, tgxerrors.ErrPublicMsgLink) || !errors.Is(err, tgxerrors.ErrInvalidMsgLink) {
+		t.Errorf("expected the public and conflicting links, got %v", err)
+	}
+
+	srv, tg, _ := newFakeChat(t)
+	chat, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, Identifier: "linked", Link: "https://t.me/c/123456789/2/21"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterChat("linked", false)
+	if target := chat.Target(); target.ChatID != -100123456789 || target.ChatTopic != 2 {
+		t.Errorf("unexpected target %+v", target)
+	}
+}
@@ -0,0 +1,152 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The output of a JSON logger, written by the polling and handler goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// The records logged with the message.
+func (b *logBuffer) records(msg string) []map[string]any {
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var record map[string]any
+		if json.Unmarshal([]byte(line), &record) == nil && record["msg"] == msg {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// Wait for a record logged with the message.
+func (b *logBuffer) waitRecord(msg string) map[string]any {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if records := b.records(msg); len(records) > 0 {
+			return records[0]
+		}
+	}
+	return nil
+}
+
+func TestLogging(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	logs := &logBuffer{}
+	tg.SetLogger(slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	msgs, err := chat.SendTextMsg(nil, "sent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The error of a request timed out has the request URL, with the token.
+	release := srv.Hold("sendMessage")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err = chat.SendTextMsgContext(ctx, nil, "timed out"); !strings.Contains(err.Error(), srv.Token) {
+		t.Fatalf("expected the token in the error, got %v", err)
+	}
+	release()
+
+	chat.RegisterHandleCommand("download", func(ctx *tgx.Context) error {
+		return errors.New("download https://api.telegram.org/file/bot" + srv.Token + "/photo.jpg failed")
+	})
+	tg.Monitor()
+	srv.PushMessage(fakeChatID, 7, tgbotapi.User{ID: 42, UserName: "alice"}, "/download")
+
+	handlerFailed := logs.waitRecord("tgx: handler failed")
+	if handlerFailed == nil {
+		t.Fatal("expected the handler error logged")
+	}
+	if output := logs.String(); strings.Contains(output, srv.Token) {
+		t.Errorf("expected no token in the logs, got\n%s", output)
+	}
+
+	sent := logs.records("tgx: send")
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 send logged, got\n%s", logs)
+	}
+	sendFailed := logs.records("tgx: send failed")
+	if len(sendFailed) != 1 {
+		t.Fatalf("expected 1 failed send logged, got\n%s", logs)
+	}
+	if e, _ := sendFailed[0]["error"].(string); !strings.Contains(e, "<bot_token>") {
+		t.Errorf("expected the token redacted, got %q", e)
+	}
+
+	tests := []struct {
+		name   string
+		record map[string]any
+		keys   []string
+	}{
+		{"send", sent[0], []string{"chat", "chat_id", "topic", "message_id", "duration"}},
+		{"send failed", sendFailed[0], []string{"chat", "chat_id", "topic", "duration", "error"}},
+		{"handler failed", handlerFailed, []string{"chat", "chat_id", "topic", "message_id", "handler", "error"}},
+	}
+	for _, test := range tests {
+		for _, key := range test.keys {
+			if _, ok := test.record[key]; !ok {
+				t.Errorf("%s: expected %s in %v", test.name, key, test.record)
+			}
+		}
+		if test.record["chat"] != "fake" || test.record["chat_id"] != float64(fakeChatID) {
+			t.Errorf("%s: unexpected chat %v", test.name, test.record)
+		}
+	}
+	if id := sent[0]["message_id"]; id != float64(msgs[0].MessageID) {
+		t.Errorf("expected the message_id of the msg sent, got %v", id)
+	}
+}
+
+func TestLoggingPanic(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	logs := &logBuffer{}
+	tg.SetLogger(slog.New(slog.NewJSONHandler(logs, nil)))
+	chat.RegisterHandleCommand("download", func(ctx *tgx.Context) error {
+		panic(errors.New("download https://api.telegram.org/file/bot" + srv.Token + "/photo.jpg failed"))
+	})
+	tg.SetErrorHandler(func(ctx *tgx.Context, handler string, err error) {
+		panic("report to https://api.telegram.org/bot" + srv.Token + "/sendMessage failed")
+	})
+	tg.Monitor()
+	srv.PushMessage(fakeChatID, 0, tgbotapi.User{ID: 42, UserName: "alice"}, "/download")
+
+	for _, msg := range []string{"tgx: handler panic", "tgx: error handler panic"} {
+		record := logs.waitRecord(msg)
+		if record == nil {
+			t.Fatalf("expected %q logged", msg)
+		}
+		if panicValue, _ := record["panic"].(string); !strings.Contains(panicValue, "<bot_token>") {
+			t.Errorf("%s: expected the token redacted, got %q", msg, panicValue)
+		}
+	}
+	if output := logs.String(); strings.Contains(output, srv.Token) {
+		t.Errorf("expected no token in the logs, got\n%s", output)
+	}
+}
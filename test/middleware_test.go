@@ -0,0 +1,97 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/0xVanfer/tgx"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// A middleware recording when it runs, before and after the handler.
+func recordMiddleware(name string, got *[]string) tgx.Middleware {
+	return func(next tgx.Handler) tgx.Handler {
+		return func(ctx *tgx.Context) error {
+			*got = append(*got, name)
+			err := next(ctx)
+			*got = append(*got, "/"+name)
+			return err
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	var got []string
+	tg.Use(recordMiddleware("tg1", &got), recordMiddleware("tg2", &got))
+	tg.UseForBot(srv.Token, recordMiddleware("bot", &got))
+	tg.UseForBot("654321:other", recordMiddleware("other bot", &got))
+	chat.Use(recordMiddleware("chat", &got))
+
+	handle := func(ctx *tgx.Context) error {
+		got = append(got, "handler")
+		return nil
+	}
+	chat.RegisterHandleCommand("ping", handle)
+	chat.RegisterHandleMsg("msg", handle)
+
+	// Commands and msg handlers are wrapped alike.
+	want := "[tg1 tg2 bot chat handler /chat /bot /tg2 /tg1]"
+	if err := chat.HandleCommand(commandMsg("/ping")); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != want {
+		t.Errorf("command: expected %s, got %v", want, got)
+	}
+	got = nil
+	if errs := chat.HandleMsg(&tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: fakeChatID}, Text: "hello"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if fmt.Sprint(got) != want {
+		t.Errorf("msg: expected %s, got %v", want, got)
+	}
+}
+
+func TestMiddlewareStop(t *testing.T) {
+	_, _, chat := newFakeChat(t)
+	errDenied := errors.New("denied")
+	// Only alice may run the handlers.
+	chat.Use(func(next tgx.Handler) tgx.Handler {
+		return func(ctx *tgx.Context) error {
+			if from := ctx.Msg().From; from == nil || from.UserName != "alice" {
+				return errDenied
+			}
+			return next(ctx)
+		}
+	})
+	var calls int
+	chat.RegisterHandleCommand("ping", func(ctx *tgx.Context) error {
+		calls++
+		return nil
+	})
+
+	msg := commandMsg("/ping")
+	if err := chat.HandleCommand(msg); err != nil || calls != 1 {
+		t.Errorf("expected alice allowed, got %v, %d calls", err, calls)
+	}
+	msg.From = &tgbotapi.User{ID: 43, UserName: "bob"}
+	if err := chat.HandleCommand(msg); !errors.Is(err, errDenied) || calls != 1 {
+		t.Errorf("expected bob denied, got %v, %d calls", err, calls)
+	}
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	_, _, chat := newFakeChat(t)
+	chat.Use(func(next tgx.Handler) tgx.Handler {
+		return func(ctx *tgx.Context) error {
+			panic("middleware panic")
+		}
+	})
+	chat.RegisterHandleCommand("ping", func(ctx *tgx.Context) error { return nil })
+
+	var panicErr *tgx.PanicError
+	if err := chat.HandleCommand(commandMsg("/ping")); !errors.As(err, &panicErr) || panicErr.Kind != "middleware" {
+		t.Errorf("expected the panic of the middleware, got %v", err)
+	}
+}
@@ -7,51 +7,46 @@ import (
 	"time"
 
 	"github.com/0xVanfer/tgx"
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 func TestMonitor(t *testing.T) {
-	monitorTopicChat.RegisterHandleMsg("aaa", func(msg *tgbotapi.Message) (err error) {
-		text := msg.Text
-		if strings.Contains(text, "aaa") {
-			_, err := monitorTopicChat.SendTextMsg(nil, "You sent a message containing 'aaa'.")
-			return err
-		}
-		return nil
-	})
+	if fakeServer != nil {
+		t.Skip("chatting with the bot needs a real bot token")
+	}
+	monitorTopicChat.RegisterHandleMsg("aaa", func(ctx *tgx.Context) (err error) {
+		_, err = ctx.Reply("You sent a message containing 'aaa'.")
+		return
+	}, tgx.WithFilter(tgx.Contains("aaa")))
 
-	monitorTopicChat.RegisterHandleMsg("xxx", func(msg *tgbotapi.Message) (err error) {
-		text := msg.Text
-		if strings.Contains(text, "xxx") {
-			_, err := monitorTopicChat.SendTextMsg(nil, "You sent a message containing 'xxx'.")
-			return err
+	monitorTopicChat.RegisterHandleMsg("xxx", func(ctx *tgx.Context) (err error) {
+		_, err = ctx.Reply("You sent a message containing 'xxx'.")
+		return
+	}, tgx.WithFilter(tgx.Contains("xxx")))
+
+	entireChat.RegisterHandleMsg("xxx", func(ctx *tgx.Context) (err error) {
+		components := []tgx.MsgComponent{
+			{Text: "You sent a message containing "},
+			{Text: "xxx", EntitiyType: "bold"},
+			{Text: "."},
+			{Text: fmt.Sprintf(" @%s", ctx.Msg().From.UserName), EntitiyType: "mention"},
 		}
-		return nil
-	})
+		_, err = ctx.ReplyComponents(components)
+		return
+	}, tgx.WithFilter(tgx.Contains("xxx")))
 
-	entireChat.RegisterHandleMsg("xxx", func(msg *tgbotapi.Message) (err error) {
-		text := msg.Text
-		if strings.Contains(text, "xxx") {
-			components := []tgx.MsgComponent{
-				{Text: "You sent a message containing "},
-				{Text: "xxx", EntitiyType: "bold"},
-				{Text: "."},
-				{Text: fmt.Sprintf(" @%s", msg.From.UserName), EntitiyType: "mention"},
-			}
-			_, err := entireChat.SendTextMsgByComponents(nil, components)
-			return err
-		}
-		return nil
-	})
+	// Capture groups of the regex are passed to the handler.
+	entireChat.RegisterHandleMsg("price", func(ctx *tgx.Context) (err error) {
+		_, err = ctx.Reply(fmt.Sprintf("Looking up the price of %s.", strings.ToUpper(ctx.NamedMatch("symbol"))))
+		return
+	}, tgx.WithFilter(tgx.Regex(`^price (?P<symbol>\w+)$`), tgx.Not(tgx.IsForwarded())))
 
-	monitorTopicChat.RegisterHandleCommand("timestamp", func(msg *tgbotapi.Message) (err error) {
-		_, err = monitorTopicChat.SendTextMsg(nil, fmt.Sprintf("Current timestamp: %d", time.Now().Unix()))
+	monitorTopicChat.RegisterHandleCommand("timestamp", func(ctx *tgx.Context) (err error) {
+		_, err = ctx.Reply(fmt.Sprintf("Current timestamp: %d", time.Now().Unix()))
 		return
 	})
 
-	monitorEverywhere.RegisterHandleCommand("datetime", func(msg *tgbotapi.Message) (err error) {
-		overrideInfo := monitorEverywhere.GetOverrideInfoFromMsg(msg)
-		_, err = monitorEverywhere.SendTextMsg(overrideInfo, time.Now().UTC().Format(time.DateTime))
+	monitorEverywhere.RegisterHandleCommand("datetime", func(ctx *tgx.Context) (err error) {
+		_, err = ctx.Reply(time.Now().UTC().Format(time.DateTime))
 		return
 	})
 
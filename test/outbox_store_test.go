@@ -0,0 +1,317 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	"github.com/0xVanfer/tgx/tgxtest"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestOutboxDedup(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	release := srv.Hold("sendMessage")
+	defer release()
+	tg.StartOutbox(tgx.OutboxConfig{Capacity: 1, Overflow: tgx.OverflowDropOldest, DedupWindow: 200 * time.Millisecond})
+
+	first := chat.Enqueue(&tgx.OutboxMsg{Key: "a", Text: "a"})
+	srv.WaitRequests("sendMessage", 1, 5*time.Second)
+	// Queued meanwhile, the handle of the queued one is returned.
+	queued := chat.Enqueue(&tgx.OutboxMsg{Key: "b", Text: "b"})
+	if again := chat.Enqueue(&tgx.OutboxMsg{Key: "b", Text: "b again"}); again != queued {
+		t.Error("expected the handle of the queued message")
+	}
+	// Dropped messages are not sent, so the key can be queued again.
+	dropped := chat.Enqueue(&tgx.OutboxMsg{Key: "c", Text: "c"})
+	if err := waitHandle(t, queued); !errors.Is(err, tgxerrors.ErrOutboxDropped) {
+		t.Errorf("expected b dropped, got %v", err)
+	}
+	resent := chat.Enqueue(&tgx.OutboxMsg{Key: "b", Text: "b"})
+	if err := waitHandle(t, dropped); !errors.Is(err, tgxerrors.ErrOutboxDropped) {
+		t.Errorf("expected c dropped, got %v", err)
+	}
+	release()
+	for _, handle := range []*tgx.SendHandle{first, resent} {
+		if err := waitHandle(t, handle); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Sent within the window.
+	if err := waitHandle(t, chat.Enqueue(&tgx.OutboxMsg{Key: "a", Text: "a"})); !errors.Is(err, tgxerrors.ErrOutboxDuplicate) {
+		t.Errorf("expected ErrOutboxDuplicate, got %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := waitHandle(t, chat.Enqueue(&tgx.OutboxMsg{Key: "a", Text: "a"})); err != nil {
+		t.Errorf("expected a sent again after the window, got %v", err)
+	}
+	if got := sentTexts(srv); fmt.Sprint(got) != "[a b a]" {
+		t.Errorf("unexpected messages %v", got)
+	}
+}
+
+func TestOutboxRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	// Left by the last run: "left" not sent, "done" sent with its key.
+	store, err := tgx.NewFileOutboxStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []*tgx.OutboxMsg{
+		{ID: "1", Chat: "fake", Text: "done", Key: "done", Time: time.Now()},
+		{ID: "2", Chat: "fake", Text: "left", Time: time.Now()},
+		{ID: "3", Chat: "unregistered", Text: "kept", Time: time.Now()},
+	} {
+		if err = store.Add(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = store.Done(&tgx.OutboxMsg{ID: "1", Key: "done"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	srv, tg, chat := newFakeChat(t)
+	if store, err = tgx.NewFileOutboxStore(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	results := make(chan string, 10)
+	tg.StartOutbox(tgx.OutboxConfig{Store: store, OnResult: func(msg *tgx.OutboxMsg, _ []*tgbotapi.Message, err error) {
+		results <- fmt.Sprint(msg.Text, err)
+	}})
+	select {
+	case result := <-results:
+		if result != "left<nil>" {
+			t.Errorf("unexpected result %s", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the message left restored")
+	}
+	if err := waitHandle(t, chat.Enqueue(&tgx.OutboxMsg{Key: "done", Text: "done"})); !errors.Is(err, tgxerrors.ErrOutboxDuplicate) {
+		t.Errorf("expected ErrOutboxDuplicate, got %v", err)
+	}
+	if err := tg.CloseOutbox(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := sentTexts(srv); fmt.Sprint(got) != "[left]" {
+		t.Errorf("unexpected messages %v", got)
+	}
+
+	// The message of the unregistered chat is kept for the next run.
+	pending, _, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Text != "kept" {
+		t.Errorf("unexpected pending %+v", pending)
+	}
+}
+
+func TestOutboxRedeliver(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	chat.SetRetry(1)
+	store, err := tgx.NewFileOutboxStore(filepath.Join(t.TempDir(), "outbox.jsonl"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	tg.StartOutbox(tgx.OutboxConfig{Store: store, RedeliverInterval: 10 * time.Millisecond})
+
+	// Telegram is down for a while, the messages after wait in order.
+	srv.Fail("sendMessage", tgxtest.ServerError(), tgxtest.ServerError(), tgxtest.ServerError())
+	first := chat.SendTextMsgAsync(nil, "first")
+	second := chat.SendTextMsgAsync(nil, "second")
+	for _, handle := range []*tgx.SendHandle{first, second} {
+		if err := waitHandle(t, handle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := sentTexts(srv); fmt.Sprint(got) != "[first second]" {
+		t.Errorf("unexpected messages %v", got)
+	}
+
+	// Permanent errors are not redelivered.
+	srv.Fail("sendMessage", tgxtest.BadRequest("chat not found"))
+	if err := waitHandle(t, chat.SendTextMsgAsync(nil, "lost")); !errors.Is(err, tgxerrors.ErrPermanent) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+	if pending, _, err := store.Load(); err != nil || len(pending) != 0 {
+		t.Errorf("expected nothing pending, got %+v, %v", pending, err)
+	}
+}
+
+func TestOutboxCloseWhileRedelivering(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	chat.SetRetry(1)
+	store, err := tgx.NewFileOutboxStore(filepath.Join(t.TempDir(), "outbox.jsonl"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	tg.StartOutbox(tgx.OutboxConfig{Store: store, RedeliverInterval: time.Hour})
+
+	srv.Fail("sendMessage", tgxtest.ServerError())
+	handle := chat.SendTextMsgAsync(nil, "redelivered")
+	srv.WaitRequests("sendMessage", 1, 5*time.Second)
+	time.Sleep(50 * time.Millisecond)
+
+	// Closing does not wait for the redelivery.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err = tg.CloseOutbox(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected closing at once, took %s", elapsed)
+	}
+	if err = waitHandle(t, handle); !errors.Is(err, tgxerrors.ErrOutboxClosed) {
+		t.Errorf("expected ErrOutboxClosed, got %v", err)
+	}
+	// Kept to be sent by the next run.
+	if pending, _, err := store.Load(); err != nil || len(pending) != 1 {
+		t.Errorf("expected the message pending, got %+v, %v", pending, err)
+	}
+}
+
+// ========== File Store ==========
+
+func TestFileOutboxStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox", "journal.jsonl")
+	store, err := tgx.NewFileOutboxStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := []*tgx.OutboxMsg{
+		{ID: "1", Chat: "fake", Key: "sent", Text: "sent"},
+		{ID: "2", Chat: "fake", Text: "pending"},
+		{ID: "3", Chat: "fake", Key: "dropped", Text: "dropped"},
+		{ID: "4", Chat: "fake", Components: [][]tgx.MsgComponent{{{Text: "bold", EntitiyType: "bold"}}}},
+	}
+	for _, msg := range msgs {
+		if err = store.Add(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = store.Done(msgs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Drop(msgs[2]); err != nil {
+		t.Fatal(err)
+	}
+	// A line cut by a crash is skipped.
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"msg":{"id":"5"`)
+	file.Close()
+	store.Close()
+
+	// Loaded the same after reopening and compacting.
+	for range 2 {
+		if store, err = tgx.NewFileOutboxStore(path, 0); err != nil {
+			t.Fatal(err)
+		}
+		pending, doneKeys, err := store.Load()
+		store.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 2 || pending[0].Text != "pending" || pending[1].ID != "4" || pending[1].Components[0][0].EntitiyType != "bold" {
+			t.Errorf("unexpected pending %+v", pending)
+		}
+		if _, ok := doneKeys["sent"]; !ok || len(doneKeys) != 1 {
+			t.Errorf("expected the key of the sent message only, got %v", doneKeys)
+		}
+	}
+
+	// Keys out of the window are forgotten.
+	if store, err = tgx.NewFileOutboxStore(path, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, doneKeys, err := store.Load(); err != nil || len(doneKeys) != 0 {
+		t.Errorf("expected no keys, got %v, %v", doneKeys, err)
+	}
+}
+
+func TestFileOutboxStoreCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	store, err := tgx.NewFileOutboxStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.SetCompactThreshold(10)
+
+	lines := func() int {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(content), "\n")
+	}
+	// Every message is done right after, the journal keeps only the pending one and the recent keys.
+	pending := &tgx.OutboxMsg{ID: "pending", Chat: "fake", Text: "pending"}
+	if err = store.Add(pending); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 100 {
+		msg := &tgx.OutboxMsg{ID: strconv.Itoa(i), Chat: "fake", Text: "sent"}
+		if i%10 == 0 {
+			msg.Key = "key" + msg.ID
+		}
+		if err = store.Add(msg); err != nil {
+			t.Fatal(err)
+		}
+		if err = store.Done(msg); err != nil {
+			t.Fatal(err)
+		}
+		if n := lines(); n > 2*11+1 {
+			t.Fatalf("expected the journal compacted, got %d lines", n)
+		}
+	}
+
+	msgs, doneKeys, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].ID != "pending" || len(doneKeys) != 10 {
+		t.Errorf("unexpected %+v, %v", msgs, doneKeys)
+	}
+}
+
+func TestFileOutboxStoreCompactFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	store, err := tgx.NewFileOutboxStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.SetCompactThreshold(1)
+
+	// The journal can not be rewritten while a directory is in the way.
+	if err = os.Mkdir(path+".tmp", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Add(&tgx.OutboxMsg{ID: "1", Chat: "fake", Text: "written"}); err != nil {
+		t.Errorf("expected the write succeeded, got %v", err)
+	}
+	if err = os.Remove(path + ".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _, err := store.Load(); err != nil || len(pending) != 1 || pending[0].ID != "1" {
+		t.Errorf("expected the message pending, got %+v, %v", pending, err)
+	}
+}
@@ -0,0 +1,185 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	"github.com/0xVanfer/tgx/tgxtest"
+)
+
+// The texts of the messages sent to the fake chat, in order.
+func sentTexts(srv *tgxtest.Server) []string {
+	var texts []string
+	for _, msg := range srv.Messages(fakeChatID) {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+// Wait for the handle, failing the test if it takes too long.
+func waitHandle(t *testing.T, handle *tgx.SendHandle) error {
+	t.Helper()
+	select {
+	case <-handle.Done():
+		_, err := handle.Wait()
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message %q not done", handle.Msg.Text)
+		return nil
+	}
+}
+
+func TestOutboxOrder(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	tg.StartOutbox(tgx.OutboxConfig{Workers: 4})
+
+	var handles []*tgx.SendHandle
+	var want []string
+	for i := range 20 {
+		want = append(want, strconv.Itoa(i))
+		handles = append(handles, chat.SendTextMsgAsync(nil, want[i]))
+	}
+	for _, handle := range handles {
+		if err := waitHandle(t, handle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := sentTexts(srv); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected the messages in order, got %v", got)
+	}
+}
+
+func TestOutboxOverflow(t *testing.T) {
+	// One message being sent and held, two queued, then the fourth overflows.
+	fill := func(t *testing.T, policy tgx.OverflowPolicy) (*tgxtest.Server, *tgx.Chat, func(), []*tgx.SendHandle) {
+		srv, tg, chat := newFakeChat(t)
+		release := srv.Hold("sendMessage")
+		t.Cleanup(release)
+		tg.StartOutbox(tgx.OutboxConfig{Capacity: 2, Overflow: policy})
+		handles := []*tgx.SendHandle{chat.SendTextMsgAsync(nil, "0")}
+		srv.WaitRequests("sendMessage", 1, 5*time.Second)
+		handles = append(handles, chat.SendTextMsgAsync(nil, "1"), chat.SendTextMsgAsync(nil, "2"))
+		return srv, chat, release, handles
+	}
+
+	t.Run("DropNewest", func(t *testing.T) {
+		srv, chat, release, handles := fill(t, tgx.OverflowDropNewest)
+		if err := waitHandle(t, chat.SendTextMsgAsync(nil, "3")); !errors.Is(err, tgxerrors.ErrOutboxFull) {
+			t.Errorf("expected ErrOutboxFull, got %v", err)
+		}
+		release()
+		for _, handle := range handles {
+			if err := waitHandle(t, handle); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := sentTexts(srv); fmt.Sprint(got) != "[0 1 2]" {
+			t.Errorf("unexpected messages %v", got)
+		}
+	})
+
+	t.Run("DropOldest", func(t *testing.T) {
+		srv, chat, release, handles := fill(t, tgx.OverflowDropOldest)
+		last := chat.SendTextMsgAsync(nil, "3")
+		if err := waitHandle(t, handles[1]); !errors.Is(err, tgxerrors.ErrOutboxDropped) {
+			t.Errorf("expected ErrOutboxDropped, got %v", err)
+		}
+		release()
+		for _, handle := range []*tgx.SendHandle{handles[0], handles[2], last} {
+			if err := waitHandle(t, handle); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := sentTexts(srv); fmt.Sprint(got) != "[0 2 3]" {
+			t.Errorf("unexpected messages %v", got)
+		}
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		srv, chat, release, handles := fill(t, tgx.OverflowBlock)
+		enqueued := make(chan *tgx.SendHandle)
+		go func() { enqueued <- chat.SendTextMsgAsync(nil, "3") }()
+		select {
+		case <-enqueued:
+			t.Fatal("expected enqueuing to wait for room")
+		case <-time.After(100 * time.Millisecond):
+		}
+		release()
+		handles = append(handles, <-enqueued)
+		for _, handle := range handles {
+			if err := waitHandle(t, handle); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := sentTexts(srv); fmt.Sprint(got) != "[0 1 2 3]" {
+			t.Errorf("unexpected messages %v", got)
+		}
+	})
+}
+
+func TestOutboxClose(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	tg.StartOutbox(tgx.OutboxConfig{})
+	var handles []*tgx.SendHandle
+	for i := range 5 {
+		handles = append(handles, chat.SendTextMsgAsync(nil, strconv.Itoa(i)))
+	}
+	// The queued messages are flushed.
+	if err := tg.CloseOutbox(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, handle := range handles {
+		if err := waitHandle(t, handle); err != nil {
+			t.Error(err)
+		}
+	}
+	if got := sentTexts(srv); len(got) != 5 {
+		t.Errorf("expected 5 messages sent, got %v", got)
+	}
+	if err := waitHandle(t, chat.SendTextMsgAsync(nil, "closed")); !errors.Is(err, tgxerrors.ErrOutboxClosed) {
+		t.Errorf("expected ErrOutboxClosed, got %v", err)
+	}
+}
+
+func TestOutboxCloseTimeout(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	release := srv.Hold("sendMessage")
+	defer release()
+	tg.StartOutbox(tgx.OutboxConfig{})
+	sending := chat.SendTextMsgAsync(nil, "sending")
+	srv.WaitRequests("sendMessage", 1, 5*time.Second)
+	queued := chat.SendTextMsgAsync(nil, "queued")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tg.CloseOutbox(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+	if err := waitHandle(t, queued); !errors.Is(err, tgxerrors.ErrOutboxClosed) {
+		t.Errorf("expected ErrOutboxClosed, got %v", err)
+	}
+	// The message being sent is not abandoned.
+	release()
+	if err := waitHandle(t, sending); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOutboxWithoutWrapper(t *testing.T) {
+	chat := &tgx.Chat{ChatID: fakeChatID, Identifier: "no-wrapper"}
+	for _, handle := range []*tgx.SendHandle{
+		chat.SendTextMsgAsync(nil, "text"),
+		chat.SendTextMsgByComponentsAsync(nil, []tgx.MsgComponent{{Text: "components"}}),
+		chat.Enqueue(&tgx.OutboxMsg{Text: "enqueued"}),
+	} {
+		if err := waitHandle(t, handle); !errors.Is(err, tgxerrors.ErrNoOutbox) {
+			t.Errorf("expected ErrNoOutbox, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/internal/tgxutils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestTokenBucket(t *testing.T) {
+	// 3 tokens per 3 seconds, one refilled every second.
+	bucket := tgxutils.NewTokenBucket(3, 3*time.Second)
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	// The burst is taken at once.
+	for i := range 3 {
+		if wait := bucket.ReserveAt(start); wait != 0 {
+			t.Errorf("token %d: expected no wait, got %s", i, wait)
+		}
+	}
+	// Then a token every second.
+	if wait := bucket.ReserveAt(start); wait != time.Second {
+		t.Errorf("expected 1s, got %s", wait)
+	}
+	if wait := bucket.ReserveAt(at(500 * time.Millisecond)); wait != 1500*time.Millisecond {
+		t.Errorf("expected 1.5s, got %s", wait)
+	}
+	// Refilled after a while, but never beyond the burst.
+	for i := range 3 {
+		if wait := bucket.ReserveAt(at(time.Minute)); wait != 0 {
+			t.Errorf("refilled token %d: expected no wait, got %s", i, wait)
+		}
+	}
+	if wait := bucket.ReserveAt(at(time.Minute)); wait != time.Second {
+		t.Errorf("expected 1s after the refilled burst, got %s", wait)
+	}
+	// Full again once all tokens are refilled.
+	if bucket.FullAt(at(time.Minute + 3*time.Second)) {
+		t.Error("expected the bucket not full before refilled")
+	}
+	if !bucket.FullAt(at(time.Minute + 4*time.Second)) {
+		t.Error("expected the bucket full after refilled")
+	}
+
+	// No limit.
+	for _, bucket := range []*tgxutils.TokenBucket{tgxutils.NewTokenBucket(0, time.Second), tgxutils.NewTokenBucket(1, 0)} {
+		if bucket != nil || bucket.Reserve() != 0 {
+			t.Error("expected a nil bucket never waiting")
+		}
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	bucket := tgxutils.NewTokenBucket(1, time.Hour)
+	if wait, err := bucket.Wait(context.Background()); wait != 0 || err != nil {
+		t.Fatalf("expected the first token at once, got %s, %v", wait, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if wait, err := bucket.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) || wait < 59*time.Minute {
+		t.Errorf("expected waiting an hour until ctx is done, got %s, %v", wait, err)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	chat.SetRetry(1)
+	other, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, ChatID: otherChatID, ChatTopic: -1, Identifier: "fake-other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterChat("fake-other", false)
+	other.SetRetry(1)
+	// Another bot has limiters of its own.
+	otherBot, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: "654321:other", ChatID: fakeChatID, ChatTopic: -1, Identifier: "fake-other-bot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterChat("fake-other-bot", false)
+	otherBot.SetRetry(1)
+
+	send := func(chat *tgx.Chat) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := chat.SendTextMsgContext(ctx, nil, "hello")
+		return err
+	}
+	limited := func(chat *tgx.Chat, want bool) {
+		t.Helper()
+		if err := send(chat); errors.Is(err, context.DeadlineExceeded) != want {
+			t.Errorf("%s: expected limited %v, got %v", chat.Identifier, want, err)
+		}
+	}
+
+	// One message per group.
+	tg.SetRateLimits(tgx.RateLimits{Bot: tgx.Rate{Count: 100, Per: time.Second}, Group: tgx.Rate{Count: 1, Per: time.Hour}})
+	limited(chat, false)
+	limited(chat, true)
+	limited(other, false)
+	limited(otherBot, false)
+
+	// New limits apply at once. One message per bot.
+	tg.SetRateLimits(tgx.RateLimits{Bot: tgx.Rate{Count: 1, Per: time.Hour}})
+	limited(chat, false)
+	limited(other, true)
+	limited(otherBot, false)
+
+	// Deleting goes through the limits too.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := other.DeleteMsgByIDContext(ctx, otherChatID, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the delete limited, got %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	msg := other.ToChatMsg(&tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: otherChatID}})
+	if err := msg.DeleteContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the delete of the msg limited, got %v", err)
+	}
+
+	// The limiter is removed with the bot.
+	if err := tg.UnregisterChat("fake-other-bot", false); err != nil {
+		t.Fatal(err)
+	}
+	if otherBot, err = tg.RegisterChat(tgx.SingleChatConf{BotToken: "654321:other", ChatID: fakeChatID, ChatTopic: -1, Identifier: "fake-other-bot"}); err != nil {
+		t.Fatal(err)
+	}
+	limited(otherBot, false)
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	"github.com/0xVanfer/tgx/tgxtest"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestWatchConfig(t *testing.T) {
+	srv := tgxtest.NewServer()
+	defer srv.Close()
+
+	chatsYAML := func(chats ...string) string {
+		content := "chats:\n"
+		for _, chat := range chats {
+			content += chat
+		}
+		return content
+	}
+	chatYAML := func(identifier string, topic int) string {
+		return fmt.Sprintf("  - {bot_token: %q, chat_id: %d, chat_topic: %d, identifier: %s}\n", srv.Token, fakeChatID, topic, identifier)
+	}
+	path := writeConfig(t, "tgx.yaml", chatsYAML(chatYAML("alerts", 2), chatYAML("logs", 3)))
+
+	tg := &tgx.TgWrapper{}
+	tg.SetBotFactory(srv.BotFactory())
+	// Chats not from the config are not touched.
+	if _, err := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, ChatID: fakeChatID, Identifier: "manual"}); err != nil {
+		t.Fatal(err)
+	}
+	stop, err := tg.WatchConfig(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	defer tg.UnregisterBot(srv.Token, false)
+
+	alerts, err := tg.GetChat("alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts.RegisterHandleCommand("ping", func(ctx *tgx.Context) (err error) {
+		_, err = ctx.Reply("pong")
+		return
+	})
+	tg.Monitor()
+	msgs, err := alerts.SendTextMsg(nil, "managed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = alerts.RegisterMsgs(msgs, "managed", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Move alerts to topic 5, remove logs and add audit.
+	if err = os.WriteFile(path, []byte(chatsYAML(chatYAML("alerts", 5), chatYAML("audit", 7))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err = tg.GetChat("audit"); err != nil && time.Now().Before(deadline); _, err = tg.GetChat("audit") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal("expected audit registered")
+	}
+	if _, err = tg.GetChat("logs"); !errors.Is(err, tgxerrors.ErrIdentifierNotFound) {
+		t.Errorf("expected logs unregistered, got %v", err)
+	}
+	if _, err = tg.GetChat("manual"); err != nil {
+		t.Errorf("expected manual kept, got %v", err)
+	}
+	current, _ := tg.GetChat("alerts")
+	if current != alerts || alerts.Target().ChatTopic != 5 {
+		t.Errorf("expected alerts updated in place, got topic %d", alerts.Target().ChatTopic)
+	}
+	if _, err = alerts.GetMsg("managed"); err != nil {
+		t.Errorf("expected the managed msg kept, got %v", err)
+	}
+	// The handlers are kept, and handle the updates of the new topic.
+	srv.PushMessage(fakeChatID, 5, tgbotapi.User{ID: 42}, "/ping")
+	if sent := srv.WaitRequests("sendMessage", 2, 5*time.Second); len(sent) != 2 || sent[1].Params.Get("text") != "pong" {
+		t.Error("expected the handlers kept")
+	}
+
+	// Invalid configs are skipped.
+	if err = os.WriteFile(path, []byte(chatsYAML(chatYAML("alerts", -5))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err = tg.GetChat("audit"); err != nil || alerts.Target().ChatTopic != 5 {
+		t.Errorf("expected the invalid config skipped, got %v", err)
+	}
+}
+
+func TestApplyConfigWhileSending(t *testing.T) {
+	srv := tgxtest.NewServer()
+	defer srv.Close()
+
+	tg := &tgx.TgWrapper{}
+	tg.SetBotFactory(srv.BotFactory())
+	chats := []tgx.SingleChatConf{{BotToken: srv.Token, ChatID: fakeChatID, Identifier: "reloaded"}}
+	if err := tg.ApplyConfig(&tgx.Config{Chats: chats}); err != nil {
+		t.Fatal(err)
+	}
+	defer tg.UnregisterBot(srv.Token, false)
+	chat, err := tg.GetChat("reloaded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Run with -race, the settings are changed while the messages are sent.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 20 {
+			if _, e := chat.SendTextMsg(nil, fmt.Sprintf("msg %d", i)); e != nil {
+				t.Error(e)
+				return
+			}
+		}
+	}()
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+			if n := len(srv.Requests("sendMessage")); n != 20 {
+				t.Errorf("expected 20 msgs sent, got %d", n)
+			}
+			return
+		default:
+		}
+		retry, interval, preview := i%3+1, time.Duration(i%5)*time.Millisecond, i%2 == 0
+		err = tg.ApplyConfig(&tgx.Config{
+			Chats:                 chats,
+			Retry:                 &retry,
+			RetryInterval:         &interval,
+			MaxFloodWait:          &interval,
+			DisableWebPagePreview: &preview,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
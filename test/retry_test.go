@@ -0,0 +1,151 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx/internal/tgxutils"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestRequestError(t *testing.T) {
+	floodWait := &tgbotapi.Error{Code: 429, Message: "Too Many Requests: retry after 3", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 3}}
+	tests := []struct {
+		err        error
+		class      classWant
+		code       int
+		retryAfter time.Duration
+	}{
+		{floodWait, wantFloodWait, 429, 3 * time.Second},
+		{fmt.Errorf("send: %w", floodWait), wantFloodWait, 429, 3 * time.Second},
+		{&tgbotapi.Error{Code: 502, Message: "Bad Gateway"}, wantRetryable, 502, 0},
+		{&tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"}, wantPermanent, 400, 0},
+		{&tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}, wantPermanent, 403, 0},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, wantRetryable, 0, 0},
+		{errors.New("unexpected EOF"), wantRetryable, 0, 0},
+		{context.Canceled, wantPermanent, 0, 0},
+		{fmt.Errorf("wait: %w", context.DeadlineExceeded), wantPermanent, 0, 0},
+		// Not from Telegram, e.g. the HTML error page of a proxy.
+		{&json.SyntaxError{}, wantRetryable, 0, 0},
+	}
+	for _, test := range tests {
+		err := tgxerrors.NewRequestError(test.err)
+		if err.Class != test.class.class || err.Code != test.code || err.RetryAfter != test.retryAfter {
+			t.Errorf("%v: expected %v %d %v, got %v %d %v", test.err, test.class.class, test.code, test.retryAfter, err.Class, err.Code, err.RetryAfter)
+		}
+		if !errors.Is(err, test.class.sentinel) || !errors.Is(err, test.err) {
+			t.Errorf("%v: expected matching %v and the original error", test.err, test.class.sentinel)
+		}
+		if err.Error() != test.err.Error() {
+			t.Errorf("expected the message of the original error, got %q", err.Error())
+		}
+		// Classified only once.
+		if again := tgxerrors.NewRequestError(fmt.Errorf("again: %w", err)); again != err {
+			t.Errorf("%v: expected the same error, got %v", test.err, again)
+		}
+	}
+	if tgxerrors.NewRequestError(nil) != nil {
+		t.Error("expected nil for nil")
+	}
+}
+
+// The class of an error and the sentinel matching it.
+type classWant struct {
+	class    tgxerrors.ErrorClass
+	sentinel error
+}
+
+var (
+	wantRetryable = classWant{tgxerrors.ClassRetryable, tgxerrors.ErrRetryable}
+	wantFloodWait = classWant{tgxerrors.ClassFloodWait, tgxerrors.ErrFloodWait}
+	wantPermanent = classWant{tgxerrors.ClassPermanent, tgxerrors.ErrPermanent}
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt     int
+		interval    time.Duration
+		maxInterval time.Duration
+		want        time.Duration
+	}{
+		{1, time.Second, 0, time.Second},
+		{2, time.Second, 0, 2 * time.Second},
+		{4, time.Second, 0, 8 * time.Second},
+		{4, time.Second, 5 * time.Second, 5 * time.Second},
+		{100, time.Second, time.Minute, time.Minute},
+		{3, 0, time.Minute, 0},
+	}
+	for _, test := range tests {
+		jittered := false
+		for range 100 {
+			wait := tgxutils.Backoff(test.attempt, test.interval, test.maxInterval)
+			// Up to ±20% jitter.
+			if wait < test.want*8/10 || wait > test.want*12/10 {
+				t.Fatalf("attempt %d of %v: expected about %v, got %v", test.attempt, test.interval, test.want, wait)
+			}
+			jittered = jittered || wait != test.want
+		}
+		if !jittered && test.want != 0 {
+			t.Errorf("attempt %d of %v: expected jitter", test.attempt, test.interval)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := tgxutils.RetryPolicy{MaxAttempts: 3, Interval: time.Millisecond, MaxFloodWait: time.Second}
+	fail := func(errs ...error) (callback func() error, calls *int) {
+		calls = new(int)
+		return func() error {
+			*calls++
+			if *calls <= len(errs) {
+				return errs[*calls-1]
+			}
+			return nil
+		}, calls
+	}
+	serverErr := &tgbotapi.Error{Code: 500, Message: "Internal Server Error"}
+
+	tests := []struct {
+		name  string
+		errs  []error
+		want  error
+		calls int
+	}{
+		{"succeeds after retries", []error{serverErr, serverErr}, nil, 3},
+		{"too many attempts", []error{serverErr, serverErr, serverErr}, tgxerrors.ErrRetryable, 3},
+		{"permanent", []error{&tgbotapi.Error{Code: 400, Message: "Bad Request"}}, tgxerrors.ErrPermanent, 1},
+		{"flood wait too long", []error{&tgbotapi.Error{Code: 429, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 2}}}, tgxerrors.ErrFloodWait, 1},
+	}
+	for _, test := range tests {
+		callback, calls := fail(test.errs...)
+		err := tgxutils.Retry(context.Background(), callback, policy)
+		if (test.want == nil) != (err == nil) || (test.want != nil && !errors.Is(err, test.want)) || *calls != test.calls {
+			t.Errorf("%s: expected %v after %d calls, got %v after %d calls", test.name, test.want, test.calls, err, *calls)
+		}
+	}
+
+	// The flood wait is waited before retrying.
+	var waits []time.Duration
+	floodPolicy := policy
+	floodPolicy.OnRetry = func(attempt int, err *tgxerrors.RequestError, wait time.Duration) { waits = append(waits, wait) }
+	callback, _ := fail(&tgbotapi.Error{Code: 429, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 1}})
+	if err := tgxutils.Retry(context.Background(), callback, floodPolicy); err != nil || len(waits) != 1 || waits[0] != time.Second {
+		t.Errorf("expected waiting 1s, got %v, %v", err, waits)
+	}
+
+	// Stops waiting once ctx is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	callback, calls := fail(serverErr)
+	slowPolicy := policy
+	slowPolicy.Interval = time.Hour
+	if err := tgxutils.Retry(ctx, callback, slowPolicy); !errors.Is(err, context.DeadlineExceeded) || *calls != 1 {
+		t.Errorf("expected the deadline exceeded after 1 call, got %v after %d calls", err, *calls)
+	}
+}
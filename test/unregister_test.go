@@ -0,0 +1,220 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxerrors"
+	"github.com/0xVanfer/tgx/tgxtest"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The identifiers of the chats of the bot, as read by the monitoring goroutines.
+func registeredChats(tg *tgx.TgWrapper, botToken string) []string {
+	info, ok := tg.GetAllRegisteredBots()[botToken]
+	if !ok {
+		return nil
+	}
+	var identifiers []string
+	for _, chat := range info.Chats {
+		identifiers = append(identifiers, chat.Identifier)
+	}
+	return identifiers
+}
+
+func TestUnregisterHandlers(t *testing.T) {
+	_, _, chat := newFakeChat(t)
+	var got []string
+	record := func(name string) tgx.Handler {
+		return func(ctx *tgx.Context) error {
+			got = append(got, name)
+			return nil
+		}
+	}
+	chat.RegisterHandleMsg("first", record("first"))
+	chat.RegisterHandleMsg("second", record("second"))
+	chat.RegisterHandleChannelPost("post", record("post"))
+
+	chat.UnregisterHandleMsg("first")
+	chat.UnregisterHandleMsg("unknown")
+	chat.UnregisterHandleUpdate(tgx.UpdateKindChannelPost, "post")
+	chat.UnregisterHandleUpdate(tgx.UpdateKindChatMember, "unknown")
+
+	msgChat := &tgbotapi.Chat{ID: fakeChatID}
+	if errs := chat.HandleMsg(&tgbotapi.Message{MessageID: 1, Chat: msgChat, Text: "hello"}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	post := &tgx.Update{Update: tgbotapi.Update{ChannelPost: &tgbotapi.Message{MessageID: 2, Chat: msgChat, Text: "post"}}}
+	if errs := chat.HandleUpdate(post); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if fmt.Sprint(got) != "[second]" {
+		t.Errorf("unexpected calls %v", got)
+	}
+}
+
+func TestUnregisterBot(t *testing.T) {
+	srv := tgxtest.NewServer()
+	defer srv.Close()
+	tg := &tgx.TgWrapper{}
+	tg.SetBotFactory(srv.BotFactory())
+	for _, conf := range []tgx.SingleChatConf{
+		{BotToken: srv.Token, ChatID: fakeChatID, Identifier: "first"},
+		{BotToken: srv.Token, ChatID: otherChatID, Identifier: "second"},
+		{BotToken: tgxtest.DefaultToken + "-other", ChatID: fakeChatID, Identifier: "other-bot"},
+	} {
+		if _, err := tg.RegisterChat(conf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer tg.UnregisterChat("other-bot", false)
+
+	if err := tg.UnregisterBot(srv.Token, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, identifier := range []string{"first", "second"} {
+		if _, err := tg.GetChat(identifier); !errors.Is(err, tgxerrors.ErrIdentifierNotFound) {
+			t.Errorf("expected %s unregistered, got %v", identifier, err)
+		}
+	}
+	if chats := registeredChats(tg, srv.Token); chats != nil {
+		t.Errorf("expected the bot removed, got %v", chats)
+	}
+	if _, err := tg.GetBotHealth(srv.Token); !errors.Is(err, tgxerrors.ErrBotNotFound) {
+		t.Errorf("expected ErrBotNotFound, got %v", err)
+	}
+	if err := tg.UnregisterBot(srv.Token, false); !errors.Is(err, tgxerrors.ErrBotNotFound) {
+		t.Errorf("expected ErrBotNotFound, got %v", err)
+	}
+	// The chats of other bots are kept.
+	if chats := registeredChats(tg, tgxtest.DefaultToken+"-other"); fmt.Sprint(chats) != "[other-bot]" {
+		t.Errorf("expected other-bot kept, got %v", chats)
+	}
+}
+
+func TestUnregisterChatWhileMonitoring(t *testing.T) {
+	srv := tgxtest.NewServer()
+	defer srv.Close()
+	tg := &tgx.TgWrapper{}
+	tg.SetBotFactory(srv.BotFactory())
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	for _, conf := range []tgx.SingleChatConf{
+		{BotToken: srv.Token, ChatID: fakeChatID, ChatTopic: -1, Identifier: "removed"},
+		{BotToken: srv.Token, ChatID: otherChatID, ChatTopic: -1, Identifier: "kept"},
+	} {
+		chat, err := tg.RegisterChat(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chat.RegisterHandleMsg("record", func(ctx *tgx.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, ctx.Chat.Identifier+": "+ctx.Msg().Text)
+			return nil
+		})
+	}
+	defer tg.UnregisterBot(srv.Token, false)
+
+	// The chats of the bot are read before the held request, and the updates are fetched after removed is unregistered.
+	release := srv.Hold("getUpdates")
+	tg.Monitor()
+	if len(srv.WaitRequests("getUpdates", 1, 5*time.Second)) != 1 {
+		t.Fatal("expected getUpdates")
+	}
+	if err := tg.UnregisterChat("removed", false); err != nil {
+		t.Fatal(err)
+	}
+	if chats := registeredChats(tg, srv.Token); fmt.Sprint(chats) != "[kept]" {
+		t.Errorf("expected only kept registered, got %v", chats)
+	}
+	srv.PushMessage(fakeChatID, 0, tgbotapi.User{ID: 42}, "to removed")
+	srv.PushMessage(otherChatID, 0, tgbotapi.User{ID: 42}, "to kept")
+	release()
+
+	waitCalls := func(n int) []string {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			mu.Lock()
+			calls := append([]string{}, got...)
+			mu.Unlock()
+			if len(calls) >= n {
+				return calls
+			}
+		}
+		return nil
+	}
+	if calls := waitCalls(1); fmt.Sprint(calls) != "[kept: to kept]" {
+		t.Errorf("expected only kept to handle, got %q", calls)
+	}
+
+	// The polling stops once the last chat is unregistered, after its current request.
+	if err := tg.UnregisterChat("kept", false); err != nil {
+		t.Fatal(err)
+	}
+	srv.PushMessage(otherChatID, 0, tgbotapi.User{ID: 42}, "after kept")
+	time.Sleep(200 * time.Millisecond)
+	n := len(srv.Requests("getUpdates"))
+	srv.PushMessage(otherChatID, 0, tgbotapi.User{ID: 42}, "not polled")
+	time.Sleep(200 * time.Millisecond)
+	if len(srv.Requests("getUpdates")) != n {
+		t.Error("expected the polling stopped")
+	}
+	if calls := waitCalls(1); len(calls) != 1 {
+		t.Errorf("expected no more calls, got %q", calls)
+	}
+}
+
+func TestUnregisterHandlerConfirmsUpdates(t *testing.T) {
+	srv, tg, chat := newFakeChat(t)
+	handled := make(chan string, 10)
+	handle := func(ctx *tgx.Context) error {
+		handled <- ctx.Msg().Text
+		return nil
+	}
+	waitHandled := func(want string) {
+		t.Helper()
+		select {
+		case text := <-handled:
+			if text != want {
+				t.Errorf("expected %q handled, got %q", want, text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %q handled", want)
+		}
+	}
+	chat.RegisterHandleMsg("record", handle)
+	tg.Monitor()
+	srv.PushMessage(fakeChatID, 0, tgbotapi.User{ID: 42}, "first")
+	waitHandled("first")
+
+	// The update fetched after the last handler is gone is confirmed before the polling stops.
+	chat.UnregisterHandleMsg("record")
+	srv.PushMessage(fakeChatID, 0, tgbotapi.User{ID: 42}, "unhandled")
+	var confirm *tgxtest.Request
+	for deadline := time.Now().Add(5 * time.Second); confirm == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, request := range srv.Requests("getUpdates") {
+			if request.Params.Get("limit") == "1" {
+				confirm = &request
+			}
+		}
+	}
+	if confirm == nil {
+		t.Fatal("expected the updates confirmed")
+	}
+	// Without waiting for new updates.
+	if confirm.Params.Get("timeout") != "" || confirm.Params.Get("offset") == "" {
+		t.Errorf("unexpected confirming params %v", confirm.Params)
+	}
+
+	// Polling again does not handle the updates before.
+	chat.RegisterHandleMsg("record", handle)
+	srv.PushMessage(fakeChatID, 0, tgbotapi.User{ID: 42}, "second")
+	waitHandled("second")
+}
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	"github.com/0xVanfer/tgx/tgxtest"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestUpdateKinds(t *testing.T) {
+	srv := tgxtest.NewServer()
+	defer srv.Close()
+	tg := &tgx.TgWrapper{}
+	tg.SetBotFactory(srv.BotFactory())
+	defer tg.UnregisterBot(srv.Token, false)
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	record := func(ctx *tgx.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		text := ""
+		if msg := ctx.Msg(); msg != nil {
+			text = " " + msg.Text
+		}
+		got = append(got, fmt.Sprintf("%s:%s%s", ctx.Chat.Identifier, ctx.Update.Kind(), text))
+		return nil
+	}
+	register := func(conf tgx.SingleChatConf) *tgx.Chat {
+		conf.BotToken = srv.Token
+		chat, err := tg.RegisterChat(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chat
+	}
+	register(tgx.SingleChatConf{ChatID: otherChatID, ChatTopic: -1, Identifier: "channel"}).RegisterHandleChannelPost("post", record)
+	topic := register(tgx.SingleChatConf{ChatID: fakeChatID, ChatTopic: 7, Identifier: "topic"})
+	topic.RegisterHandleEditedMsg("edited", record)
+	// Updates without a message are not in any topic.
+	topic.RegisterHandleChatJoinRequest("join", record)
+	topic.RegisterHandleMessageReaction("reaction", record)
+	register(tgx.SingleChatConf{ChatID: fakeChatID, ChatTopic: -1, Identifier: "members"}).RegisterHandleChatMember("member", record)
+	register(tgx.SingleChatConf{Identifier: "everywhere"}).RegisterHandlePollAnswer("poll", record)
+	tg.Monitor()
+
+	edited := func(topic int, text string) tgx.Update {
+		msg := &tgbotapi.Message{MessageID: 21, Chat: &tgbotapi.Chat{ID: fakeChatID, Type: "supergroup"}, Text: text}
+		if topic > 0 {
+			msg.ReplyToMessage = &tgbotapi.Message{MessageID: topic, Chat: msg.Chat}
+		}
+		return tgx.Update{Update: tgbotapi.Update{EditedMessage: msg}}
+	}
+	post := func(chatID int64, text string) tgx.Update {
+		return tgx.Update{Update: tgbotapi.Update{ChannelPost: &tgbotapi.Message{MessageID: 22, Chat: &tgbotapi.Chat{ID: chatID, Type: "channel"}, Text: text}}}
+	}
+	for _, update := range []tgx.Update{
+		edited(3, "other topic"),
+		edited(7, "in topic"),
+		post(fakeChatID, "other channel"),
+		post(otherChatID, "published"),
+		{Update: tgbotapi.Update{ChatMember: &tgbotapi.ChatMemberUpdated{
+			Chat:          tgbotapi.Chat{ID: fakeChatID, Type: "supergroup"},
+			From:          tgbotapi.User{ID: 42},
+			OldChatMember: tgbotapi.ChatMember{User: &tgbotapi.User{ID: 43}, Status: "left"},
+			NewChatMember: tgbotapi.ChatMember{User: &tgbotapi.User{ID: 43}, Status: "member"},
+		}}},
+		{Update: tgbotapi.Update{ChatJoinRequest: &tgbotapi.ChatJoinRequest{
+			Chat: tgbotapi.Chat{ID: fakeChatID, Type: "supergroup"},
+			From: tgbotapi.User{ID: 44},
+		}}},
+		{MessageReaction: &tgx.MessageReactionUpdated{
+			Chat:        &tgbotapi.Chat{ID: fakeChatID, Type: "supergroup"},
+			MessageID:   21,
+			User:        &tgbotapi.User{ID: 42},
+			NewReaction: []tgx.ReactionType{{Type: "emoji", Emoji: "👍"}},
+		}},
+		{Update: tgbotapi.Update{PollAnswer: &tgbotapi.PollAnswer{PollID: "poll", User: tgbotapi.User{ID: 42}, OptionIDs: []int{1}}}},
+	} {
+		srv.PushUpdate(update)
+	}
+
+	want := []string{
+		"channel:channel_post published",
+		"everywhere:poll_answer",
+		"members:chat_member",
+		"topic:chat_join_request",
+		"topic:edited_message in topic",
+		"topic:message_reaction",
+	}
+	var calls []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		calls = slices.Clone(got)
+		mu.Unlock()
+		if len(calls) >= len(want) {
+			break
+		}
+	}
+	// The updates are handled concurrently across chats.
+	slices.Sort(calls)
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("unexpected calls %q", calls)
+	}
+
+	// Telegram only sends the kinds asked for.
+	requests := srv.Requests("getUpdates")
+	if len(requests) == 0 {
+		t.Fatal("expected getUpdates")
+	}
+	allowed := requests[len(requests)-1].Params.Get("allowed_updates")
+	for _, kind := range []tgx.UpdateKind{tgx.UpdateKindChannelPost, tgx.UpdateKindEditedMessage, tgx.UpdateKindChatMember, tgx.UpdateKindChatJoinRequest, tgx.UpdateKindPollAnswer, tgx.UpdateKindMessageReaction} {
+		if !strings.Contains(allowed, `"`+string(kind)+`"`) {
+			t.Errorf("expected %s in allowed_updates %s", kind, allowed)
+		}
+	}
+	if strings.Contains(allowed, `"`+string(tgx.UpdateKindMessage)+`"`) {
+		t.Errorf("expected no message in allowed_updates %s", allowed)
+	}
+}
@@ -0,0 +1,25 @@
+package tgxerrors
+
+import (
+	"errors"
+	"strings"
+)
+
+// Match any *ConfigError with errors.Is().
+var ErrInvalidConfig = errors.New("tgx: invalid config")
+
+// ConfigError is an invalid entry of the config, see tgx.LoadConfig().
+// Several ConfigErrors are joined by errors.Join().
+type ConfigError struct {
+	Path string // The entry, e.g. "chats[1].bot_token".
+	Err  error
+}
+
+// e.g. "tgx: config chats[1].bot_token: bot_token is invalid".
+func (e *ConfigError) Error() string {
+	return "tgx: config " + e.Path + ": " + strings.TrimPrefix(e.Err.Error(), "tgx: ")
+}
+
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+func (e *ConfigError) Is(target error) bool { return target == ErrInvalidConfig }
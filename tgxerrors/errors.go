@@ -0,0 +1,42 @@
+package tgxerrors
+
+import (
+	"errors"
+)
+
+// Common errors
+var (
+	ErrIdentifierEmpty         = errors.New("tgx: identifier is empty")       // Identifier is "".
+	ErrIdentifierNotFound      = errors.New("tgx: identifier not found")      // Identifier not found when getting.
+	ErrIdentifierAlreadyExists = errors.New("tgx: identifier already exists") // Identifier already exist when registering.
+
+	ErrTextTooLong     = errors.New("tgx: text length is too long")     // Text length > 4096.
+	ErrTooManyEntities = errors.New("tgx: entities length is too long") // Entities length > 100.
+
+	ErrZeroChatID    = errors.New("tgx: chat_id is 0")
+	ErrEmptyBotToken = errors.New("tgx: bot_token is empty")
+	ErrBotNotFound   = errors.New("tgx: no chat registered for the bot")
+
+	ErrInvalidBotToken = errors.New("tgx: bot_token is invalid")     // Not like "123456:ABC-DEF".
+	ErrBotNotReady     = errors.New("tgx: bot is not connected yet") // Lazily registered bot still connecting.
+
+	ErrMsgNotFound = errors.New("tgx: msg or msg.Chat is nil")
+
+	ErrInvalidMsgLink = errors.New("tgx: invalid message link")       // Not like https://t.me/c/123456789/2/21.
+	ErrNoMsgLink      = errors.New("tgx: message has no link")        // Messages of private chats and basic groups.
+	ErrPublicMsgLink  = errors.New("tgx: public link has no chat ID") // Links like https://t.me/username/21.
+
+	ErrHandlerTimeout  = errors.New("tgx: handler timeout")   // Handler takes longer than the handler timeout.
+	ErrHandlerNotFound = errors.New("tgx: handler not found") // No handler registered with the identifier.
+
+	ErrOutboxFull      = errors.New("tgx: outbox is full")          // The outbox is full with OverflowDropNewest.
+	ErrOutboxDropped   = errors.New("tgx: dropped from the outbox") // Dropped for a newer message with OverflowDropOldest.
+	ErrOutboxClosed    = errors.New("tgx: outbox is closed")
+	ErrOutboxDuplicate = errors.New("tgx: duplicate outbox key") // A message with the same key is already sent.
+	ErrNoOutbox        = errors.New("tgx: chat has no outbox")   // The chat is not created by a wrapper.
+
+	ErrCommandMissingArg  = errors.New("tgx: missing argument")   // Required arg or flag value not given.
+	ErrCommandTooManyArgs = errors.New("tgx: too many arguments") // More args than declared.
+	ErrCommandInvalidArg  = errors.New("tgx: invalid argument")   // Arg or flag can not be parsed as its type.
+	ErrCommandUnknownFlag = errors.New("tgx: unknown flag")       // Flag not declared.
+)
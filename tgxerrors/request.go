@@ -0,0 +1,95 @@
+package tgxerrors
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// How a failed request should be retried.
+type ErrorClass int
+
+const (
+	ClassRetryable ErrorClass = iota // Temporary failures, e.g. network errors and 5xx. Retried with backoff.
+	ClassFloodWait                   // 429 Too Many Requests. Retried after RetryAfter.
+	ClassPermanent                   // e.g. "chat not found", "message is not modified". Never retried.
+)
+
+func (class ErrorClass) String() string {
+	switch class {
+	case ClassFloodWait:
+		return "flood_wait"
+	case ClassPermanent:
+		return "permanent"
+	default:
+		return "retryable"
+	}
+}
+
+// Match the class of a *RequestError with errors.Is().
+var (
+	ErrRetryable = errors.New("tgx: retryable error")
+	ErrFloodWait = errors.New("tgx: flood wait")
+	ErrPermanent = errors.New("tgx: permanent error")
+)
+
+// RequestError is the error of a failed request to Telegram.
+//
+// Use errors.Is(err, ErrFloodWait) etc. to tell the class,
+// or errors.As() with *RequestError to get the details.
+type RequestError struct {
+	Class      ErrorClass
+	Code       int           // The HTTP error code from Telegram, 0 if the request did not reach Telegram.
+	RetryAfter time.Duration // Only for ClassFloodWait.
+	Err        error         // The original error, usually *tgbotapi.Error.
+}
+
+// The message of the original error, e.g. "Bad Request: chat not found".
+func (e *RequestError) Error() string { return e.Err.Error() }
+
+func (e *RequestError) Unwrap() error { return e.Err }
+
+func (e *RequestError) Is(target error) bool {
+	switch target {
+	case ErrRetryable:
+		return e.Class == ClassRetryable
+	case ErrFloodWait:
+		return e.Class == ClassFloodWait
+	case ErrPermanent:
+		return e.Class == ClassPermanent
+	}
+	return false
+}
+
+// Classify the error of a request. Returns nil for nil, and err itself if it is already a *RequestError.
+func NewRequestError(err error) *RequestError {
+	if err == nil {
+		return nil
+	}
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr
+	}
+
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		requestErr = &RequestError{Class: ClassRetryable, Code: apiErr.Code, Err: err}
+		switch {
+		case apiErr.Code == 429:
+			requestErr.Class = ClassFloodWait
+			requestErr.RetryAfter = time.Duration(apiErr.RetryAfter) * time.Second
+		case apiErr.Code >= 400 && apiErr.Code < 500:
+			requestErr.Class = ClassPermanent
+		}
+		return requestErr
+	}
+
+	// Not an API error: the request may have not reached Telegram, or the response is not from Telegram,
+	// e.g. the HTML error page of a proxy in front of the Bot API server, which can not be decoded.
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return &RequestError{Class: ClassPermanent, Err: err}
+	}
+	return &RequestError{Class: ClassRetryable, Err: err}
+}
@@ -0,0 +1,522 @@
+// Package tgxtest provides an in-process fake Telegram Bot API server, to test bots built on tgx without network.
+//
+//	srv := tgxtest.NewServer()
+//	defer srv.Close()
+//	tg := &tgx.TgWrapper{}
+//	tg.SetBotFactory(srv.BotFactory())
+//	chat, _ := tg.RegisterChat(tgx.SingleChatConf{BotToken: srv.Token, ChatID: -100123, Identifier: "alerts"})
+//
+// The server keeps the messages sent by the bot in memory, records every request,
+// and lets tests push incoming updates and make the next requests fail.
+//
+// The server can also be used as the API endpoint, instead of the bot factory:
+//
+//	tg.SetAPIEndpoint(srv.URL)
+//	tg.SetHTTPClient(srv.Client())
+package tgxtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xVanfer/tgx"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The token of the bots of a new server, see Server.Token. The server accepts requests of any token.
+const DefaultToken = "123456:tgxtest"
+
+// Server is a fake Telegram Bot API server.
+type Server struct {
+	*httptest.Server
+
+	Token   string        // The token of Bot(), default DefaultToken.
+	BotUser tgbotapi.User // Returned by getMe.
+
+	mu        sync.Mutex
+	changed   chan struct{} // Closed and replaced when a request is received or an update is pushed.
+	done      chan struct{} // Closed by Close(), to end the long polling getUpdates.
+	nextMsgID int
+	msgs      map[int64]map[int]*tgbotapi.Message // map[chat id][message id] = message sent by the bot
+	requests  []Request
+	failures  map[string][]Failure
+	holds     map[string]chan struct{} // map[method] = closed to release the requests of the method
+	files     map[string][]byte        // map[file id] = content of the uploaded file
+
+	nextUpdateID int
+	updates      []json.RawMessage // The updates not confirmed by the offset of getUpdates.
+	updateIDs    []int
+}
+
+// A request received by the server.
+type Request struct {
+	Method string
+	Params url.Values
+	Files  map[string]string // map[field] = file name, for uploads.
+	Time   time.Time
+}
+
+// An error returned by the server instead of handling the request.
+type Failure struct {
+	Code        int
+	Description string
+	RetryAfter  int // Seconds, for 429.
+
+	// Served as is instead of a Bot API error, e.g. the HTML error page of a proxy.
+	Body string
+}
+
+// FloodWait is the failure of 429 Too Many Requests.
+func FloodWait(retryAfter int) Failure {
+	return Failure{Code: 429, Description: fmt.Sprintf("Too Many Requests: retry after %d", retryAfter), RetryAfter: retryAfter}
+}
+
+// BadRequest is the failure of 400 Bad Request, e.g. BadRequest("chat not found").
+func BadRequest(description string) Failure {
+	return Failure{Code: 400, Description: "Bad Request: " + description}
+}
+
+// Unauthorized is the failure of 401 Unauthorized, returned by Telegram for unknown bot tokens.
+func Unauthorized() Failure {
+	return Failure{Code: 401, Description: "Unauthorized"}
+}
+
+// ServerError is the failure of 502 Bad Gateway, which is retried by tgx.
+func ServerError() Failure {
+	return Failure{Code: 502, Description: "Bad Gateway"}
+}
+
+// GatewayError is the failure of 502 Bad Gateway served by a proxy in front of the Bot API server,
+// with an HTML page instead of a Bot API error. It is retried by tgx.
+func GatewayError() Failure {
+	return Failure{Code: 502, Body: "<html><head><title>502 Bad Gateway</title></head><body>502 Bad Gateway</body></html>"}
+}
+
+// NewServer starts a fake Bot API server. Close it after use.
+func NewServer() *Server {
+	s := &Server{
+		Token: DefaultToken,
+		BotUser: tgbotapi.User{
+			ID:        123456,
+			IsBot:     true,
+			FirstName: "tgxtest",
+			UserName:  "tgxtest_bot",
+		},
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+		msgs:     make(map[int64]map[int]*tgbotapi.Message),
+		failures: make(map[string][]Failure),
+		holds:    make(map[string]chan struct{}),
+		files:    make(map[string][]byte),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Close the server, ending the requests waiting for updates.
+func (s *Server) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	s.mu.Unlock()
+	s.Server.Close()
+}
+
+// Bot returns a BotAPI of s.Token talking to the server.
+func (s *Server) Bot() tgx.BotAPI {
+	bot, err := s.BotFactory()(s.Token)
+	if err != nil {
+		panic(err)
+	}
+	return bot
+}
+
+// BotFactory returns the factory creating BotAPIs talking to the server, see TgWrapper.SetBotFactory().
+func (s *Server) BotFactory() tgx.BotFactory {
+	return func(botToken string) (tgx.BotAPI, error) {
+		bot, err := tgbotapi.NewBotAPIWithClient(botToken, s.URL+"/bot%s/%s", s.Client())
+		if err != nil {
+			return nil, err
+		}
+		return &tgx.TgBotAPI{BotAPI: bot}, nil
+	}
+}
+
+// ========== Injecting ==========
+
+// Fail makes the next requests of the method fail, one failure for each request.
+// Use method "" to fail the next requests of any method.
+func (s *Server) Fail(method string, failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], failures...)
+}
+
+// Hold makes the requests of the method wait until release is called or the server is closed, e.g. to fill a queue.
+// The requests are recorded when received, before waiting.
+func (s *Server) Hold(method string) (release func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hold := make(chan struct{})
+	s.holds[method] = hold
+	return sync.OnceFunc(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.holds[method] == hold {
+			delete(s.holds, method)
+		}
+		close(hold)
+	})
+}
+
+// PushUpdate queues the update to be returned by getUpdates. The UpdateID is set by the server.
+func (s *Server) PushUpdate(update tgx.Update) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextUpdateID++
+	update.UpdateID = s.nextUpdateID
+	raw, err := json.Marshal(update)
+	if err != nil {
+		panic(err)
+	}
+	s.updates = append(s.updates, raw)
+	s.updateIDs = append(s.updateIDs, update.UpdateID)
+	s.notify()
+	return update.UpdateID
+}
+
+// PushMessage queues an incoming message from the user, in the topic of the chat if topic > 0.
+// Commands (text starting with "/") get their bot_command entity.
+func (s *Server) PushMessage(chatID int64, topic int, from tgbotapi.User, text string) *tgbotapi.Message {
+	s.mu.Lock()
+	s.nextMsgID++
+	msg := &tgbotapi.Message{
+		MessageID: s.nextMsgID,
+		From:      &from,
+		Chat:      newChat(chatID),
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+	s.mu.Unlock()
+
+	if topic > 0 {
+		msg.ReplyToMessage = &tgbotapi.Message{MessageID: topic, Chat: msg.Chat}
+	}
+	if strings.HasPrefix(text, "/") {
+		length, _, _ := strings.Cut(text, " ")
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(length)}}
+	}
+	s.PushUpdate(tgx.Update{Update: tgbotapi.Update{Message: msg}})
+	return msg
+}
+
+// ========== Asserting ==========
+
+// Requests returns the requests received of the method, "" for all methods, in the order received.
+func (s *Server) Requests(method string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []Request
+	for _, request := range s.requests {
+		if method == "" || request.Method == method {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// WaitRequests waits until n requests of the method are received, or the timeout. Returns the requests received.
+func (s *Server) WaitRequests(method string, n int, timeout time.Duration) []Request {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		s.mu.Unlock()
+		if requests := s.Requests(method); len(requests) >= n {
+			return requests
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return s.Requests(method)
+		}
+	}
+}
+
+// Messages returns the messages sent by the bot to the chat and not deleted, in the order sent.
+func (s *Server) Messages(chatID int64) []*tgbotapi.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []*tgbotapi.Message
+	for _, msg := range s.msgs[chatID] {
+		copied := *msg
+		msgs = append(msgs, &copied)
+	}
+	slices.SortFunc(msgs, func(a, b *tgbotapi.Message) int { return a.MessageID - b.MessageID })
+	return msgs
+}
+
+// Message returns the message sent by the bot, nil if not sent or deleted.
+func (s *Server) Message(chatID int64, msgID int) *tgbotapi.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg, ok := s.msgs[chatID][msgID]
+	if !ok {
+		return nil
+	}
+	copied := *msg
+	return &copied
+}
+
+// ========== Serving ==========
+
+// Must hold mu.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/file/") {
+		s.serveFile(w, r)
+		return
+	}
+	// The path is /bot<token>/<method>.
+	_, method, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/bot"), "/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	request := Request{Method: method, Files: make(map[string]string), Time: time.Now()}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			writeFailure(w, BadRequest(err.Error()))
+			return
+		}
+		for field, files := range r.MultipartForm.File {
+			request.Files[field] = files[0].Filename
+			if content, err := readFile(files[0]); err == nil {
+				s.mu.Lock()
+				s.files[files[0].Filename] = content
+				s.mu.Unlock()
+			}
+		}
+	} else if err := r.ParseForm(); err != nil {
+		writeFailure(w, BadRequest(err.Error()))
+		return
+	}
+	request.Params = r.Form
+
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	s.notify()
+	failure, failed := s.takeFailure(method)
+	hold, held := s.holds[method]
+	s.mu.Unlock()
+	if held {
+		select {
+		case <-hold:
+		case <-s.done:
+		}
+	}
+	if failed {
+		writeFailure(w, failure)
+		return
+	}
+
+	var (
+		result     any
+		apiFailure *Failure
+	)
+	switch method {
+	case "getMe":
+		result = s.BotUser
+	case "getUpdates":
+		result = s.getUpdates(r.Form)
+	case "sendMessage":
+		result = s.sendMessage(r.Form, nil)
+	case "sendPhoto":
+		photo := r.Form.Get("photo")
+		if name, ok := request.Files["photo"]; ok {
+			photo = name
+		}
+		result = s.sendMessage(r.Form, []tgbotapi.PhotoSize{{FileID: photo, FileUniqueID: photo}})
+	case "getFile":
+		fileID := r.Form.Get("file_id")
+		result = tgbotapi.File{FileID: fileID, FileUniqueID: fileID, FilePath: "files/" + fileID}
+	case "editMessageText":
+		result, apiFailure = s.editMessageText(r.Form)
+	case "deleteMessage":
+		result, apiFailure = s.deleteMessage(r.Form)
+	default:
+		// e.g. setMyCommands, deleteMyCommands, setMessageReaction. Check them by Requests().
+		result = true
+	}
+	if apiFailure != nil {
+		writeFailure(w, *apiFailure)
+		return
+	}
+	writeResult(w, result)
+}
+
+// Must hold mu.
+func (s *Server) takeFailure(method string) (Failure, bool) {
+	for _, key := range []string{method, ""} {
+		if failures := s.failures[key]; len(failures) > 0 {
+			s.failures[key] = failures[1:]
+			return failures[0], true
+		}
+	}
+	return Failure{}, false
+}
+
+func (s *Server) getUpdates(params url.Values) []json.RawMessage {
+	offset, _ := strconv.Atoi(params.Get("offset"))
+	timeout, _ := strconv.Atoi(params.Get("timeout"))
+	deadline := time.After(time.Duration(timeout) * time.Second)
+	for {
+		s.mu.Lock()
+		// Updates before the offset are confirmed, Telegram forgets them.
+		for len(s.updateIDs) > 0 && s.updateIDs[0] < offset {
+			s.updates, s.updateIDs = s.updates[1:], s.updateIDs[1:]
+		}
+		updates := slices.Clone(s.updates)
+		changed := s.changed
+		s.mu.Unlock()
+		if len(updates) > 0 {
+			return updates
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return []json.RawMessage{}
+		case <-s.done:
+			return []json.RawMessage{}
+		}
+	}
+}
+
+func (s *Server) sendMessage(params url.Values, photo []tgbotapi.PhotoSize) *tgbotapi.Message {
+	chatID, _ := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextMsgID++
+	bot := s.BotUser
+	msg := &tgbotapi.Message{
+		MessageID: s.nextMsgID,
+		From:      &bot,
+		Chat:      newChat(chatID),
+		Date:      int(time.Now().Unix()),
+		Text:      params.Get("text"),
+		Caption:   params.Get("caption"),
+		Photo:     photo,
+	}
+	_ = json.Unmarshal([]byte(params.Get("entities")), &msg.Entities)
+	if replyTo, _ := strconv.Atoi(params.Get("reply_to_message_id")); replyTo != 0 {
+		msg.ReplyToMessage = &tgbotapi.Message{MessageID: replyTo, Chat: msg.Chat}
+	}
+	if s.msgs[chatID] == nil {
+		s.msgs[chatID] = make(map[int]*tgbotapi.Message)
+	}
+	s.msgs[chatID][msg.MessageID] = msg
+	return msg
+}
+
+func (s *Server) editMessageText(params url.Values) (*tgbotapi.Message, *Failure) {
+	chatID, _ := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+	msgID, _ := strconv.Atoi(params.Get("message_id"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg, ok := s.msgs[chatID][msgID]
+	if !ok {
+		failure := BadRequest("message to edit not found")
+		return nil, &failure
+	}
+	var entities []tgbotapi.MessageEntity
+	_ = json.Unmarshal([]byte(params.Get("entities")), &entities)
+	if msg.Text == params.Get("text") && slices.Equal(msg.Entities, entities) {
+		failure := BadRequest("message is not modified: specified new message content and reply markup are exactly the same as a current content and reply markup of the message")
+		return nil, &failure
+	}
+	msg.Text, msg.Entities = params.Get("text"), entities
+	msg.EditDate = int(time.Now().Unix())
+	copied := *msg
+	return &copied, nil
+}
+
+func (s *Server) deleteMessage(params url.Values) (bool, *Failure) {
+	chatID, _ := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+	msgID, _ := strconv.Atoi(params.Get("message_id"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.msgs[chatID][msgID]; !ok {
+		failure := BadRequest("message to delete not found")
+		return false, &failure
+	}
+	delete(s.msgs[chatID], msgID)
+	return true, nil
+}
+
+// Group and channel IDs are negative.
+func newChat(chatID int64) *tgbotapi.Chat {
+	if chatID < 0 {
+		return &tgbotapi.Chat{ID: chatID, Type: "supergroup"}
+	}
+	return &tgbotapi.Chat{ID: chatID, Type: "private"}
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func writeFailure(w http.ResponseWriter, failure Failure) {
+	if failure.Body != "" {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(failure.Code)
+		_, _ = io.WriteString(w, failure.Body)
+		return
+	}
+	body := map[string]any{"ok": false, "error_code": failure.Code, "description": failure.Description}
+	if failure.RetryAfter > 0 {
+		body["parameters"] = map[string]any{"retry_after": failure.RetryAfter}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(failure.Code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// Serve the uploaded files at /file/bot<token>/files/<file id>, the URL returned by Chat.GetFileURL().
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	_, fileID, _ := strings.Cut(r.URL.Path, "/files/")
+	s.mu.Lock()
+	content, ok := s.files[fileID]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = w.Write(content)
+}
+
+func readFile(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
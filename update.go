@@ -0,0 +1,299 @@
+package tgx
+
+import (
+	"context"
+	"encoding/json"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The kind of an incoming update.
+// Values are the same as the names used by Telegram in "allowed_updates".
+type UpdateKind string
+
+const (
+	UpdateKindMessage           UpdateKind = "message"
+	UpdateKindEditedMessage     UpdateKind = "edited_message"
+	UpdateKindChannelPost       UpdateKind = "channel_post"
+	UpdateKindEditedChannelPost UpdateKind = "edited_channel_post"
+	UpdateKindMyChatMember      UpdateKind = "my_chat_member"
+	UpdateKindChatMember        UpdateKind = "chat_member"
+	UpdateKindChatJoinRequest   UpdateKind = "chat_join_request"
+	UpdateKindPollAnswer        UpdateKind = "poll_answer"
+	UpdateKindMessageReaction   UpdateKind = "message_reaction"
+	UpdateKindUnknown           UpdateKind = ""
+)
+
+// Update is tgbotapi.Update with the update kinds the library does not decode yet.
+type Update struct {
+	tgbotapi.Update
+
+	MessageReaction *MessageReactionUpdated `json:"message_reaction,omitempty"`
+}
+
+// A change of a reaction on a message performed by a user.
+type MessageReactionUpdated struct {
+	Chat        *tgbotapi.Chat `json:"chat"`
+	MessageID   int            `json:"message_id"`
+	User        *tgbotapi.User `json:"user,omitempty"`       // Empty if the reaction was anonymous.
+	ActorChat   *tgbotapi.Chat `json:"actor_chat,omitempty"` // The chat on behalf of which the reaction was changed, if anonymous.
+	Date        int            `json:"date"`
+	OldReaction []ReactionType `json:"old_reaction"`
+	NewReaction []ReactionType `json:"new_reaction"`
+}
+
+type ReactionType struct {
+	Type          string `json:"type"`                      // "emoji", "custom_emoji" or "paid"
+	Emoji         string `json:"emoji,omitempty"`           // For "emoji" type.
+	CustomEmojiID string `json:"custom_emoji_id,omitempty"` // For "custom_emoji" type.
+}
+
+// Kind returns the kind of the update. Kinds not supported by tgx are UpdateKindUnknown.
+func (u *Update) Kind() UpdateKind {
+	switch {
+	case u.Message != nil:
+		return UpdateKindMessage
+	case u.EditedMessage != nil:
+		return UpdateKindEditedMessage
+	case u.ChannelPost != nil:
+		return UpdateKindChannelPost
+	case u.EditedChannelPost != nil:
+		return UpdateKindEditedChannelPost
+	case u.MyChatMember != nil:
+		return UpdateKindMyChatMember
+	case u.ChatMember != nil:
+		return UpdateKindChatMember
+	case u.ChatJoinRequest != nil:
+		return UpdateKindChatJoinRequest
+	case u.PollAnswer != nil:
+		return UpdateKindPollAnswer
+	case u.MessageReaction != nil:
+		return UpdateKindMessageReaction
+	default:
+		return UpdateKindUnknown
+	}
+}
+
+// Msg returns the message carried by message, edited message, channel post and edited channel post updates.
+// For other kinds, returns nil.
+func (u *Update) Msg() *tgbotapi.Message {
+	switch {
+	case u.Message != nil:
+		return u.Message
+	case u.EditedMessage != nil:
+		return u.EditedMessage
+	case u.ChannelPost != nil:
+		return u.ChannelPost
+	case u.EditedChannelPost != nil:
+		return u.EditedChannelPost
+	default:
+		return nil
+	}
+}
+
+// FromChat returns the chat the update belongs to.
+// Poll answers do not belong to any chat, so nil is returned for them.
+func (u *Update) FromChat() *tgbotapi.Chat {
+	if msg := u.Msg(); msg != nil {
+		return msg.Chat
+	}
+	switch {
+	case u.MyChatMember != nil:
+		return &u.MyChatMember.Chat
+	case u.ChatMember != nil:
+		return &u.ChatMember.Chat
+	case u.ChatJoinRequest != nil:
+		return &u.ChatJoinRequest.Chat
+	case u.MessageReaction != nil:
+		return u.MessageReaction.Chat
+	default:
+		return nil
+	}
+}
+
+// SentFrom returns the user who triggered the update, nil if unknown (e.g. channel posts).
+func (u *Update) SentFrom() *tgbotapi.User {
+	if msg := u.Msg(); msg != nil {
+		return msg.From
+	}
+	switch {
+	case u.MyChatMember != nil:
+		return &u.MyChatMember.From
+	case u.ChatMember != nil:
+		return &u.ChatMember.From
+	case u.ChatJoinRequest != nil:
+		return &u.ChatJoinRequest.From
+	case u.PollAnswer != nil:
+		return &u.PollAnswer.User
+	case u.MessageReaction != nil:
+		return u.MessageReaction.User
+	default:
+		return nil
+	}
+}
+
+// Topic returns the topic the update belongs to.
+//
+// Consider the messageID of the msg reply to is the topic, same as the rest of tgx.
+// Updates without a message are considered to be in topic 0, though the chats of any topic handle them.
+func (u *Update) Topic() int {
+	if msg := u.Msg(); msg != nil && msg.ReplyToMessage != nil {
+		return msg.ReplyToMessage.MessageID
+	}
+	return 0
+}
+
+// ========== Handlers ==========
+
+// Register a function to handle edited messages.
+func (chat *Chat) RegisterHandleEditedMsg(funcIdentifier string, handleFunc Handler, opts ...HandlerOption) {
+	chat.registerHandleUpdate(UpdateKindEditedMessage, funcIdentifier, handleFunc, opts...)
+}
+
+// Register a function to handle new channel posts.
+func (chat *Chat) RegisterHandleChannelPost(funcIdentifier string, handleFunc Handler, opts ...HandlerOption) {
+	chat.registerHandleUpdate(UpdateKindChannelPost, funcIdentifier, handleFunc, opts...)
+}
+
+// Register a function to handle edited channel posts.
+func (chat *Chat) RegisterHandleEditedChannelPost(funcIdentifier string, handleFunc Handler, opts ...HandlerOption) {
+	chat.registerHandleUpdate(UpdateKindEditedChannelPost, funcIdentifier, handleFunc, opts...)
+}
+
+// Register a function to handle the changes of the bot's own member status in the chat.
+func (chat *Chat) RegisterHandleMyChatMember(funcIdentifier string, handleFunc Handler, opts ...HandlerOption) {
+	chat.registerHandleUpdate(UpdateKindMyChatMember, funcIdentifier, handleFunc, opts...)
+}
+
+// Register a function to handle the changes of other members' status in the chat.
+// The bot must be an administrator in the chat to receive these updates.
+func (chat *Chat) RegisterHandleChatMember(funcIdentifier string, handleFunc Handler, opts ...HandlerOption) {
+	chat.registerHandleUpdate(UpdateKindChatMember, funcIdentifier, handleFunc, opts...)
+}
+
+// Register a function to handle requests to join the chat.
+// The bot must have the can_invite_users administrator right in the chat to receive these updates.
+func (chat *Chat) RegisterHandleChatJoinRequest(funcIdentifier string, handleFunc Handler, opts ...HandlerOption) {
+	chat.registerHandleUpdate(UpdateKindChatJoinRequest, funcIdentifier, handleFunc, opts...)
+}
+
+// Register a function to handle answers of non-anonymous polls sent by the bot.
+//
+// Poll answers do not belong to any chat, so they are only delivered to chats with ChatID 0.
+func (chat *Chat) RegisterHandlePollAnswer(funcIdentifier string, handleFunc Handler, opts ...HandlerOption) {
+	chat.registerHandleUpdate(UpdateKindPollAnswer, funcIdentifier, handleFunc, opts...)
+}
+
+// Register a function to handle reaction changes on messages.
+// The bot must be an administrator in the chat to receive these updates.
+func (chat *Chat) RegisterHandleMessageReaction(funcIdentifier string, handleFunc Handler, opts ...HandlerOption) {
+	chat.registerHandleUpdate(UpdateKindMessageReaction, funcIdentifier, handleFunc, opts...)
+}
+
+// Unregister the handler of the update kind, e.g. the one registered by RegisterHandleChannelPost().
+// Nothing happens if it is not registered.
+//
+// Use UnregisterHandleCommand() and UnregisterHandleMsg() for messages.
+func (chat *Chat) UnregisterHandleUpdate(kind UpdateKind, funcIdentifier string) {
+	chat.handlersMu.Lock()
+	defer chat.handlersMu.Unlock()
+	if chat.handleUpdateFuncs != nil {
+		chat.handleUpdateFuncs[kind] = removeHandler(chat.handleUpdateFuncs[kind], funcIdentifier)
+	}
+}
+
+// HandleUpdate runs the handlers registered for the kind of the update.
+// Messages are handled by HandleCommand() and HandleMsg().
+//
+// The errors are returned as map[funcIdentifier] = error. Command errors use the command as the key.
+func (chat *Chat) HandleUpdate(update *Update) map[string]error {
+	return chat.handleUpdate(withDispatchState(context.Background()), update)
+}
+
+func (chat *Chat) handleUpdate(ctx context.Context, update *Update) map[string]error {
+	if update.Kind() == UpdateKindMessage {
+		err := chat.handleCommand(ctx, update)
+		errorRes := chat.handleMsg(ctx, update)
+		if err != nil {
+			errorRes["/"+update.Message.Command()] = err
+		}
+		return errorRes
+	}
+
+	chat.handlersMu.RLock()
+	handlers := append([]*registeredHandler{}, chat.handleUpdateFuncs[update.Kind()]...)
+	concurrently := chat.handleConcurrently
+	chat.handlersMu.RUnlock()
+
+	return chat.runHandlers(ctx, update, handlers, concurrently)
+}
+
+func (chat *Chat) registerHandleUpdate(kind UpdateKind, funcIdentifier string, handleFunc Handler, opts ...HandlerOption) {
+	h := &registeredHandler{
+		identifier: funcIdentifier,
+		handle: func(ctx *Context) (err error) {
+			defer chat.recoverHandler(string(kind), funcIdentifier, &err)
+			return handleFunc(ctx)
+		},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	chat.handlersMu.Lock()
+	if chat.handleUpdateFuncs == nil {
+		chat.handleUpdateFuncs = make(map[UpdateKind][]*registeredHandler)
+	}
+	chat.handleUpdateFuncs[kind] = chat.addHandler(chat.handleUpdateFuncs[kind], h)
+	chat.handlersMu.Unlock()
+
+	chat.onHandlerRegistered()
+}
+
+// Whether the update should be handled by the chat.
+func (chat *Chat) matchUpdate(update *Update) bool {
+	target := chat.Target()
+	// If chat.ChatID is 0, should handle all updates.
+	if target.ChatID == 0 {
+		return true
+	}
+	// Otherwise, only handle updates in the chat with the same chat ID.
+	fromChat := update.FromChat()
+	if fromChat == nil || fromChat.ID != target.ChatID {
+		return false
+	}
+	// If chat topic is set to negative, ignore the topic. It will handle all updates under the chatID.
+	// Replies under topic will be ignored.
+	// Updates without a message (member changes, join requests, reactions) have no topic, all topics of the chat handle them.
+	if target.ChatTopic >= 0 && update.Msg() != nil && update.Topic() != target.ChatTopic {
+		return false
+	}
+	return true
+}
+
+// The update kinds the chat has handlers for.
+func (chat *Chat) handledUpdateKinds() (kinds []UpdateKind) {
+	chat.handlersMu.RLock()
+	defer chat.handlersMu.RUnlock()
+
+	if len(chat.commands) > 0 || len(chat.handleMsgFuncs) > 0 {
+		kinds = append(kinds, UpdateKindMessage)
+	}
+	for kind, funcs := range chat.handleUpdateFuncs {
+		if len(funcs) > 0 {
+			kinds = append(kinds, kind)
+		}
+	}
+	return
+}
+
+// Same as bot.GetUpdates(), but decodes the updates into tgx Update.
+func getUpdates(bot BotAPI, conf tgbotapi.UpdateConfig) ([]Update, error) {
+	resp, err := bot.Request(conf)
+	if err != nil {
+		return nil, err
+	}
+	var updates []Update
+	err = json.Unmarshal(resp.Result, &updates)
+	return updates, err
+}
@@ -1,11 +1,13 @@
 package tgx
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
+	"net/http"
 	"sync"
 	"time"
 
-	"github.com/0xVanfer/tgx/internal/tgxerrors"
+	"github.com/0xVanfer/tgx/tgxerrors"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
@@ -21,8 +23,66 @@ type TgWrapper struct {
 	chatsByIdentifier sync.Map // map[identifier(string)]*Chat
 
 	allRelatedBots sync.Map // map[bot token(string)][]*Chat
+
+	// The BotAPI of each token, created once and shared by the chats of the token.
+	bots sync.Map // map[bot token(string)]*registeredBot
+
+	// Guards the read-modify-write of allRelatedBots, and the monitoring state below.
+	botsMu     sync.Mutex
+	monitoring bool            // Whether Monitor() is called.
+	polling    map[string]bool // map[bot token] = whether the bot is being monitored
+	joining    map[string]int  // map[bot token] = the chats being registered with the bot, not in allRelatedBots yet
+
+	// Processing updates concurrently. Use SetWorkers(), SetQueueSize(), SetUpdateOrdering() and SetHandlerTimeout() to set.
+	workers        int
+	queueSize      int
+	ordering       UpdateOrdering
+	handlerTimeout time.Duration
+
+	// Rate limits of sending, nil for DefaultRateLimits. Use SetRateLimits() to set.
+	rateLimits *RateLimits
+	limiters   sync.Map // map[bot token(string)]*rateLimiter
+
+	// Sends messages in the background. Use StartOutbox() to start.
+	outbox *Outbox
+
+	// The chats applied by ApplyConfig(), map[identifier]conf. Guarded by configMu, which serializes the reloads.
+	configChats map[string]SingleChatConf
+	configMu    sync.Mutex
+
+	// The command menus pushed by SyncCommands(), map[bot token]menus. Guarded by syncMu, which serializes the syncs.
+	syncedMenus map[string]map[commandMenu]bool
+	syncMu      sync.Mutex
+
+	// Connects the bots in the background when registering chats. Use SetLazyRegistration() or InitLazy() to set.
+	lazy bool
+
+	// Creates the BotAPIs when registering chats, nil for NewBotAPIWithEndpoint(). Use SetBotFactory() to set.
+	botFactory BotFactory
+
+	// The Bot API server of the bots created. Use SetAPIEndpoint(), SetFileEndpoint() and SetHTTPClient() to set.
+	apiEndpoint  string
+	fileEndpoint string
+	httpClient   *http.Client
+
+	// nil to use slog.Default(). Use SetLogger() to set.
+	logger *slog.Logger
+
+	// Called with the errors of handlers. Use SetErrorHandler() and SetDeadLetterStore() to set.
+	errorHandler ErrorHandler
+	deadLetters  DeadLetterStore
+
+	// The built-in /help is enabled by default. Use SetDisableHelp() to disable it.
+	disableHelp bool
+
+	// Middlewares wrapping all handlers. Use Use() and UseForBot() to add.
+	middlewares    []Middleware
+	botMiddlewares map[string][]Middleware // map[bot token] = middlewares
+	middlewaresMu  sync.RWMutex
 }
 
+func (tg *TgWrapper) SetDisableHelp(disable bool) { tg.disableHelp = disable }
+
 // Get the chat information by identifier.
 func (tg *TgWrapper) GetChat(identifier string) (*Chat, error) {
 	if identifier == "" {
@@ -44,9 +104,21 @@ func (tg *TgWrapper) RegisterChat(conf SingleChatConf) (*Chat, error) {
 	if conf.Identifier == "" {
 		return nil, tgxerrors.ErrIdentifierEmpty
 	}
+	if conf.BotToken == "" && conf.Bot != nil {
+		conf.BotToken = conf.Bot.Token()
+	}
 	if conf.BotToken == "" {
 		return nil, tgxerrors.ErrEmptyBotToken
 	}
+	if tg.lazy {
+		if err := validateLazyConf(conf); err != nil {
+			return nil, err
+		}
+	}
+	conf, err := conf.resolveLink()
+	if err != nil {
+		return nil, err
+	}
 
 	// Identifier should be unique.
 	_, exist := tg.chatsByIdentifier.Load(conf.Identifier)
@@ -54,50 +126,167 @@ func (tg *TgWrapper) RegisterChat(conf SingleChatConf) (*Chat, error) {
 		return nil, tgxerrors.ErrIdentifierAlreadyExists
 	}
 
-	var bot *tgbotapi.BotAPI
-	bot, err := tgbotapi.NewBotAPI(conf.BotToken)
+	tg.botsMu.Lock()
+	tg.join(conf.BotToken, 1)
+	tg.botsMu.Unlock()
+	bot, err := tg.loadOrCreateBot(conf)
 	if err != nil {
+		tg.botsMu.Lock()
+		tg.join(conf.BotToken, -1)
+		tg.botsMu.Unlock()
 		return nil, err
 	}
 
 	tgChat := &Chat{
 		Bot:         bot,
+		wrapper:     tg,
 		ChatID:      conf.ChatID,
 		ChatTopic:   conf.ChatTopic,
 		Identifier:  conf.Identifier,
 		Description: conf.Description,
 
+		fileEndpoint: tg.fileEndpointOf(conf),
+
 		disableWebPagePreview: true,
 		retry:                 3,
 		retryInterval:         time.Second,
+		retryMaxInterval:      30 * time.Second,
+		maxFloodWait:          time.Minute,
 
-		managedMsgs:        sync.Map{},
-		handleCommandFuncs: make(map[string]func(msg *tgbotapi.Message) (err error)),
-		handleMsgFuncs:     make(map[string]func(msg *tgbotapi.Message) (err error)),
+		managedMsgs:    sync.Map{},
+		commands:       make(map[string]*Command),
+		commandAliases: make(map[string]string),
 	}
 
-	tg.chatsByIdentifier.Store(conf.Identifier, tgChat)
+	// Another chat with the same identifier may be registered in the meantime.
+	if _, loaded := tg.chatsByIdentifier.LoadOrStore(conf.Identifier, tgChat); loaded {
+		// Do not keep the bot created for this chat, if no other chat uses it.
+		tg.botsMu.Lock()
+		tg.join(conf.BotToken, -1)
+		if _, ok := tg.allRelatedBots.Load(conf.BotToken); !ok && tg.joining[conf.BotToken] == 0 {
+			tg.removeBot(conf.BotToken)
+		}
+		tg.botsMu.Unlock()
+		return nil, tgxerrors.ErrIdentifierAlreadyExists
+	}
 
+	tg.botsMu.Lock()
+	tg.join(conf.BotToken, -1)
 	if bots, ok := tg.allRelatedBots.Load(conf.BotToken); !ok {
 		tg.allRelatedBots.Store(conf.BotToken, []*Chat{tgChat})
 	} else {
-		tg.allRelatedBots.Store(conf.BotToken, append(bots.([]*Chat), tgChat))
+		// Copy on write, the slice may be read by the monitoring goroutines.
+		chats := append([]*Chat{}, bots.([]*Chat)...)
+		tg.allRelatedBots.Store(conf.BotToken, append(chats, tgChat))
 	}
+	tg.botsMu.Unlock()
+
+	// The new chat may come with handlers already registered on the other chats of the bot.
+	tg.startPolling(conf.BotToken)
 	return tgChat, nil
 }
 
+// Unregister the chat by identifier.
+// If it is the last chat of its bot, the bot is no longer monitored.
+//
+// If deleteMsgs is true, the managed messages of the chat are deleted from telegram too.
+func (tg *TgWrapper) UnregisterChat(identifier string, deleteMsgs bool) error {
+	chat, err := tg.GetChat(identifier)
+	if err != nil {
+		return err
+	}
+	tg.chatsByIdentifier.Delete(identifier)
+
+	tg.botsMu.Lock()
+	for botToken, info := range tg.GetAllRegisteredBots() {
+		chats := make([]*Chat, 0, len(info.Chats))
+		for _, c := range info.Chats {
+			if c != chat {
+				chats = append(chats, c)
+			}
+		}
+		if len(chats) == len(info.Chats) {
+			continue
+		}
+		if len(chats) == 0 {
+			// The monitoring goroutine stops after its current request.
+			tg.allRelatedBots.Delete(botToken)
+			// Keep the bot for the chats being registered with it.
+			if tg.joining[botToken] == 0 {
+				tg.removeBot(botToken)
+			}
+		} else {
+			tg.allRelatedBots.Store(botToken, chats)
+		}
+	}
+	tg.botsMu.Unlock()
+
+	if deleteMsgs {
+		return chat.DeleteAllMsgs()
+	}
+	return nil
+}
+
+// Count the chats being registered with the bot. Must hold botsMu.
+func (tg *TgWrapper) join(botToken string, delta int) {
+	if tg.joining == nil {
+		tg.joining = make(map[string]int)
+	}
+	if tg.joining[botToken] += delta; tg.joining[botToken] == 0 {
+		delete(tg.joining, botToken)
+	}
+}
+
+// Unregister all chats of the bot, and stop monitoring it.
+//
+// If deleteMsgs is true, the managed messages of the chats are deleted from telegram too.
+func (tg *TgWrapper) UnregisterBot(botToken string, deleteMsgs bool) error {
+	info := tg.getBotInfo(botToken)
+	if info == nil {
+		return tgxerrors.ErrBotNotFound
+	}
+	for _, chat := range info.Chats {
+		err := tg.UnregisterChat(chat.Identifier, deleteMsgs)
+		if err != nil && err != tgxerrors.ErrIdentifierNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
 type botInfo struct {
-	Bot   *tgbotapi.BotAPI
+	Bot   BotAPI
 	Chats []*Chat
+
+	wrapper *TgWrapper
+}
+
+// Whether the chat is still registered. b.Chats is read before getting the updates,
+// chats unregistered while handling them must not handle the rest.
+func (b *botInfo) isRegistered(chat *Chat) bool {
+	if b.wrapper == nil {
+		return true
+	}
+	registered, ok := b.wrapper.chatsByIdentifier.Load(chat.Identifier)
+	return ok && registered == chat
 }
 
 func (b *botInfo) hasHandler() bool {
+	return len(b.allowedUpdates()) > 0
+}
+
+// The update kinds any of the chats has handlers for.
+func (b *botInfo) allowedUpdates() (kinds []string) {
+	seen := make(map[UpdateKind]bool)
 	for _, chat := range b.Chats {
-		if len(chat.handleCommandFuncs) > 0 || len(chat.handleMsgFuncs) > 0 {
-			return true
+		for _, kind := range chat.handledUpdateKinds() {
+			if !seen[kind] {
+				seen[kind] = true
+				kinds = append(kinds, string(kind))
+			}
 		}
 	}
-	return false
+	return
 }
 
 // By reading 'tg.allRelatedBots', we can get all registered bots.
@@ -118,8 +307,9 @@ func (tg *TgWrapper) GetAllRegisteredBots() (bots map[string]*botInfo) {
 		}
 		if _, exist := bots[botToken]; !exist {
 			bots[botToken] = &botInfo{
-				Bot:   chats[0].Bot,
-				Chats: chats,
+				Bot:     tg.getBot(botToken),
+				Chats:   chats,
+				wrapper: tg,
 			}
 		} else {
 			bots[botToken].Chats = append(bots[botToken].Chats, chats...)
@@ -130,60 +320,191 @@ func (tg *TgWrapper) GetAllRegisteredBots() (bots map[string]*botInfo) {
 	return
 }
 
-// Monitor all registered bots for incoming commands.
+// Get the bot information by bot token, nil if no chat is registered for the bot.
+func (tg *TgWrapper) getBotInfo(botToken string) *botInfo {
+	value, ok := tg.allRelatedBots.Load(botToken)
+	if !ok {
+		return nil
+	}
+	chats, ok := value.([]*Chat)
+	if !ok || len(chats) == 0 {
+		return nil
+	}
+	return &botInfo{
+		Bot:     tg.getBot(botToken),
+		Chats:   chats,
+		wrapper: tg,
+	}
+}
+
+// Monitor all registered bots for incoming updates.
+//
+// Messages are handled by the registered commands and msg handlers,
+// other update kinds by the handlers registered for them (e.g. RegisterHandleChannelPost()).
+//
+// Bots without any handler are not monitored until a handler is registered.
+// Chats and handlers can be registered at any time, including after Monitor() is called.
 func (tg *TgWrapper) Monitor() {
+	tg.botsMu.Lock()
+	tg.monitoring = true
+	tg.botsMu.Unlock()
+
+	for botToken := range tg.GetAllRegisteredBots() {
+		tg.startPolling(botToken)
+	}
+}
+
+// Start monitoring the bot if Monitor() is called, the bot has handlers and is not monitored yet.
+func (tg *TgWrapper) startPolling(botToken string) {
+	tg.botsMu.Lock()
+	defer tg.botsMu.Unlock()
+
+	if !tg.monitoring || tg.polling[botToken] {
+		return
+	}
+	info := tg.getBotInfo(botToken)
+	if info == nil || info.Bot == nil {
+		// No bot registered, skip this bot.
+		return
+	}
+	if !info.hasHandler() {
+		// No handler registered, skip this bot.
+		return
+	}
+	if tg.polling == nil {
+		tg.polling = make(map[string]bool)
+	}
+	tg.polling[botToken] = true
+	go tg.poll(info.Bot)
+}
+
+// Mark the bot as not monitored if it has no chats or handlers, returns whether it is marked.
+// Checked under the lock, so a handler registered at the same time either sees the bot monitored or starts it again.
+func (tg *TgWrapper) stopPolling(botToken string) bool {
+	tg.botsMu.Lock()
+	defer tg.botsMu.Unlock()
+
+	if info := tg.getBotInfo(botToken); info != nil && info.hasHandler() {
+		return false
+	}
+	delete(tg.polling, botToken)
+	return true
+}
+
+// Mark the bot as not monitored if the registered bot is still the failed conn, returns whether it is marked.
+func (tg *TgWrapper) stopPollingFailed(botToken string, conn *lazyConn) bool {
+	tg.botsMu.Lock()
+	defer tg.botsMu.Unlock()
+
+	if info := tg.getBotInfo(botToken); info != nil {
+		if lazy, ok := info.Bot.(*lazyBot); !ok || lazy.lazyConn != conn {
+			// Registered again meanwhile.
+			return false
+		}
+	}
+	delete(tg.polling, botToken)
+	return true
+}
+
+// Keep getting updates of the bot and handle them, until the bot has no chats or handlers.
+//
+// Updates are processed by the workers of the bot, see SetWorkers().
+func (tg *TgWrapper) poll(bot BotAPI) {
+	d := newDispatcher(tg.workers, tg.queueSize)
+	defer d.close()
+
 	updatesConf := tgbotapi.NewUpdate(0)
 	updatesConf.Timeout = 10 // TODO: make it configurable?
-
-	bots := tg.GetAllRegisteredBots()
-	for _, info := range bots {
-		if info.Bot == nil {
-			// No bot registered, skip this bot.
+	confirmed := 0           // The offset confirmed to Telegram before stopping.
+	for {
+		// Read the chats every time, chats and handlers may be registered while monitoring.
+		b := tg.getBotInfo(bot.Token())
+		if b == nil || !b.hasHandler() {
+			// Telegram forgets the updates before the offset only when getting updates with it,
+			// otherwise the updates handled are fetched again once polling restarts from offset 0.
+			if updatesConf.Offset > confirmed {
+				tg.confirmUpdates(bot, updatesConf.Offset)
+				confirmed = updatesConf.Offset
+			}
+			if tg.stopPolling(bot.Token()) {
+				return
+			}
+			// A chat with handlers is registered meanwhile, read it again.
+			continue
+		}
+		// Lazily registered bots are polled once connected.
+		if lazy, ok := b.Bot.(*lazyBot); ok && !lazy.connected() {
+			// Bots failed to connect are not polled, until registered again.
+			if lazy.failed() && tg.stopPollingFailed(bot.Token(), lazy.lazyConn) {
+				return
+			}
+			time.Sleep(time.Second)
 			continue
 		}
-		if !info.hasHandler() {
-			// No handler registered, skip this bot.
+		// Telegram does not send some kinds (e.g. chat_member) unless asked for explicitly.
+		updatesConf.AllowedUpdates = b.allowedUpdates()
+		updates, err := getUpdates(b.Bot, updatesConf)
+		tg.reportBotError(bot.Token(), err)
+		if err != nil {
+			requestErr := tgxerrors.NewRequestError(err)
+			// Wait as long as Telegram asks to on flood waits.
+			wait := max(time.Second*3, requestErr.RetryAfter)
+			tg.getLogger().Error("tgx: get updates failed",
+				slog.String("bot", bot.Self().UserName),
+				slog.String("class", requestErr.Class.String()),
+				slog.Duration("wait", wait),
+				slog.String("error", redactToken(err.Error(), bot.Token())),
+			)
+			time.Sleep(wait)
 			continue
 		}
-		go func(b *botInfo) {
-			bot := b.Bot
-			updates := bot.GetUpdatesChan(updatesConf)
-			for update := range updates {
-				if update.Message == nil || update.Message.Chat == nil {
-					continue
-				}
-				// Actually will not use this.
-				if update.Message.From.ID == bot.Self.ID {
-					continue
-				}
-				// Search for the chat by chat ID.
-				for _, chat := range b.Chats {
-					// If chat.ChatID is 0, should handle all messages.
-					// Otherwise, only handle messages in the chat with the same chat ID.
-					if chat.ChatID != 0 && chat.ChatID != update.Message.Chat.ID {
-						continue
-					}
-					// Consider the messageID of the msg reply to is the topic.
-					// Replies under topic will be ignored.
-					var topic int
-					if update.Message.ReplyToMessage != nil {
-						topic = update.Message.ReplyToMessage.MessageID
-					}
-					// If chat topic is set to negative, ignore the topic. It will handle all messages under the chatID.
-					if chat.ChatID != 0 && chat.ChatTopic >= 0 && topic != chat.ChatTopic {
-						continue
-					}
-
-					err := chat.HandleCommand(update.Message)
-					if err != nil {
-						fmt.Printf("Error in chat [%s]: %s\n", chat.Identifier, err.Error())
-					}
-					errors := chat.HandleMsg(update.Message)
-					for _, err := range errors {
-						fmt.Printf("Error in chat [%s]: %s\n", chat.Identifier, err.Error())
-					}
-				}
+		for _, update := range updates {
+			if update.UpdateID >= updatesConf.Offset {
+				updatesConf.Offset = update.UpdateID + 1
 			}
-		}(info)
+			d.dispatch(tg.orderingKey(&update), func() {
+				ctx := withDispatchState(context.Background())
+				b.handleUpdate(ctx, &update)
+				// Handlers timed out may be still running, the next updates wait for them.
+				dispatchStateFrom(ctx).wait()
+			})
+		}
+	}
+}
+
+// Confirm the updates before the offset, without waiting for new updates.
+func (tg *TgWrapper) confirmUpdates(bot BotAPI, offset int) {
+	conf := tgbotapi.NewUpdate(offset)
+	conf.Limit = 1
+	conf.Timeout = 0
+	if _, err := bot.Request(conf); err != nil {
+		tg.getLogger().Error("tgx: confirm updates failed",
+			slog.String("bot", bot.Self().UserName),
+			slog.Int("offset", offset),
+			slog.String("error", redactToken(err.Error(), bot.Token())),
+		)
+	}
+}
+
+func (b *botInfo) handleUpdate(ctx context.Context, update *Update) {
+	// Actually will not use this.
+	if from := update.SentFrom(); from != nil && update.Msg() != nil && from.ID == b.Bot.Self().ID {
+		return
+	}
+	if chat, err := b.handleHelp(ctx, update); chat != nil && err != nil {
+		chat.reportHandlerError(ctx, update, "/"+helpCommandName, err)
+	}
+	// Search for the chat by chat ID and topic, in the order the chats are registered.
+	for _, chat := range b.Chats {
+		if dispatchStateFrom(ctx).isStopped() {
+			return
+		}
+		if !b.isRegistered(chat) || !chat.matchUpdate(update) {
+			continue
+		}
+		errors := chat.handleUpdate(ctx, update)
+		for handler, err := range errors {
+			chat.reportHandlerError(ctx, update, handler, err)
+		}
 	}
 }